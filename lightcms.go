@@ -1,22 +1,329 @@
 package lightcms
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	s "git.urantiatech.com/cloudcms/lightcms/service"
 	"git.urantiatech.com/pkg/lang"
+	"github.com/go-kit/kit/endpoint"
 	h "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 	"golang.org/x/text/language"
 )
 
+// Options configures a Server
+type Options struct {
+	// DBFile is the path of database file
+	DBFile string
+
+	// IndexFile is the path of the persistent index directory, indexes are
+	// kept in memory if it is empty
+	IndexFile string
+
+	// Languages supported, English is used if empty
+	Languages []language.Tag
+
+	// UploadDir holds the partial files of resumable uploads, "uploads" by
+	// default
+	UploadDir string
+
+	// StrictFields rejects content fields missing from the field definitions
+	StrictFields bool
+
+	// Storage selects where uploaded files are stored, the local "drive"
+	// directory by default
+	Storage s.StorageConfig
+
+	// CacheTTL is the expiration of cached read responses, 5 minutes by
+	// default, caching is disabled if it is negative
+	CacheTTL time.Duration
+
+	// Fallback serves missing items in fallback languages, Fallbacks
+	// overrides the chain of a language, e.g. "pt-BR" to "pt" and "en".
+	// Languages fall back to their BCP 47 parents and then to the first
+	// language by default.
+	Fallback  bool
+	Fallbacks map[string][]string
+
+	// MaxRevisions is the number of revisions kept per item, 50 by default,
+	// all revisions are kept if it is negative
+	MaxRevisions int
+
+	// Images configures the resized variants served from /drive/, they are
+	// disabled unless Images.Sizes is set
+	Images s.ImageConfig
+
+	// Authenticator identifies users, requests are anonymous if it is nil
+	// and only reads are allowed by DefaultPolicy
+	Authenticator s.Authenticator
+
+	// Policy defines the roles required per operation, DefaultPolicy is
+	// used if it is nil
+	Policy *s.Policy
+
+	// SchedulerInterval is how often publish_at and unpublish_at are
+	// checked, every minute by default
+	SchedulerInterval time.Duration
+
+	// Timeouts used by ListenAndServe
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// Server is a CMS instance owning its database, indexes and cache
+type Server struct {
+	opts    Options
+	svc     *s.Service
+	handler http.Handler
+
+	// Close waits for the handlers in flight, later requests are refused
+	mu       sync.Mutex
+	closed   bool
+	inflight sync.WaitGroup
+}
+
+// New opens the database, rebuilds stale indexes and creates the routes
+func New(opts Options) (*Server, error) {
+	if opts.DBFile == "" {
+		opts.DBFile = "db/cloudcms.db"
+	}
+	// Using English as default language
+	if len(opts.Languages) == 0 {
+		opts.Languages = []language.Tag{language.English}
+	}
+	if opts.UploadDir == "" {
+		opts.UploadDir = "uploads"
+	}
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = 5 * time.Minute
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = 5 * time.Minute
+	}
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = 2 * time.Minute
+	}
+	if opts.SchedulerInterval == 0 {
+		opts.SchedulerInterval = time.Minute
+	}
+	if opts.ShutdownTimeout == 0 {
+		opts.ShutdownTimeout = 30 * time.Second
+	}
+	if err := s.CheckAuthenticator(opts.Authenticator); err != nil {
+		return nil, err
+	}
+
+	storage, err := s.NewStorage(opts.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := &s.Service{
+		DBFile:       opts.DBFile,
+		IndexFile:    opts.IndexFile,
+		Languages:    opts.Languages,
+		UploadDir:    opts.UploadDir,
+		StrictFields: opts.StrictFields,
+		Storage:      storage,
+		CacheTTL:     opts.CacheTTL,
+		Fallback:     opts.Fallback,
+		Fallbacks:    opts.Fallbacks,
+		MaxRevisions: opts.MaxRevisions,
+	}
+	if err := svc.Initialize(); err != nil {
+		return nil, err
+	}
+	if err := svc.RebuildIndex(); err != nil {
+		svc.Close()
+		return nil, err
+	}
+	svc.StartScheduler(opts.SchedulerInterval)
+
+	// Authenticate every request and authorize each operation
+	if opts.Authenticator == nil {
+		log.Print("No authenticator configured, all requests are anonymous")
+		opts.Authenticator = s.Authenticators{}
+	}
+	if opts.Policy == nil {
+		opts.Policy = s.DefaultPolicy()
+	}
+	handler := func(e endpoint.Endpoint, op s.Operation, dec h.DecodeRequestFunc, enc h.EncodeResponseFunc) http.Handler {
+		return h.NewServer(s.UploadSessionType(svc)(s.Authorize(opts.Policy, op)(e)), dec, enc,
+			h.ServerBefore(s.Authenticate(opts.Authenticator), s.Conditions(op)),
+			h.ServerErrorEncoder(s.EncodeError))
+	}
+
+	r := mux.NewRouter()
+	r.Handle("/create", handler(s.CreateEndpoint(svc), s.OpCreate, s.DecodeCreateReq, s.Encode))
+	r.Handle("/read", handler(s.ReadEndpoint(svc), s.OpRead, s.DecodeReadReq, s.Encode))
+	r.Handle("/update", handler(s.UpdateEndpoint(svc), s.OpUpdate, s.DecodeUpdateReq, s.Encode))
+	r.Handle("/delete", handler(s.DeleteEndpoint(svc), s.OpDelete, s.DecodeDeleteReq, s.Encode))
+	r.Handle("/search", handler(s.SearchEndpoint(svc), s.OpRead, s.DecodeSearchReq, s.Encode))
+	r.Handle("/facets", handler(s.FacetsSearchEndpoint(svc), s.OpRead, s.DecodeFacetsSearchReq, s.Encode))
+	r.Handle("/list", handler(s.ListEndpoint(svc), s.OpRead, s.DecodeListReq, s.Encode))
+	r.Handle("/schema", handler(s.SchemaEndpoint(svc), s.OpRead, s.DecodeSchemaReq, s.Encode))
+	r.Handle("/trash", handler(s.TrashEndpoint(svc), s.OpTrash, s.DecodeListReq, s.Encode))
+	r.Handle("/restore", handler(s.RestoreEndpoint(svc), s.OpTrash, s.DecodeDeleteReq, s.Encode))
+	r.Handle("/purge", handler(s.PurgeEndpoint(svc), s.OpPurge, s.DecodeDeleteReq, s.Encode))
+	r.Handle("/revisions", handler(s.RevisionsEndpoint(svc), s.OpHistory, s.DecodeRevisionReq, s.Encode))
+	r.Handle("/revision", handler(s.RevisionEndpoint(svc), s.OpHistory, s.DecodeRevisionReq, s.Encode))
+	r.Handle("/diff", handler(s.DiffEndpoint(svc), s.OpHistory, s.DecodeRevisionReq, s.Encode))
+	r.Handle("/rollback", handler(s.RollbackEndpoint(svc), s.OpUpdate, s.DecodeRevisionReq, s.Encode))
+	r.Handle("/rename", handler(s.RenameEndpoint(svc), s.OpUpdate, s.DecodeRenameReq, s.Encode))
+	r.Handle("/translations", handler(s.TranslationsEndpoint(svc), s.OpRead, s.DecodeTranslationsReq, s.Encode))
+	r.Handle("/translations/missing", handler(s.MissingTranslationsEndpoint(svc), s.OpCreate, s.DecodeMissingTranslationsReq, s.Encode))
+	r.Handle("/translate", handler(s.TranslateEndpoint(svc), s.OpCreate, s.DecodeTranslateReq, s.Encode))
+	r.Handle("/gc", handler(s.GCEndpoint(svc), s.OpPurge, s.DecodeGCReq, s.Encode))
+	r.Handle("/cache/stats", handler(s.CacheStatsEndpoint(svc), s.OpAdmin, s.DecodeCacheStatsReq, s.Encode))
+	r.Handle("/languages", handler(s.LanguagesEndpoint(svc), s.OpAdmin, s.DecodeLanguagesReq, s.Encode))
+	r.Handle("/languages/enable", handler(s.EnableLanguageEndpoint(svc), s.OpAdmin, s.DecodeLanguageReq, s.Encode))
+	r.Handle("/languages/disable", handler(s.DisableLanguageEndpoint(svc), s.OpAdmin, s.DecodeLanguageReq, s.Encode))
+	r.Handle("/languages/archive", handler(s.ArchiveLanguageEndpoint(svc), s.OpAdmin, s.DecodeLanguageReq, s.Encode))
+	r.Handle("/types/create", handler(s.CreateTypeEndpoint(svc), s.OpAdmin, s.DecodeTypeReq, s.Encode))
+	r.Handle("/types/update", handler(s.UpdateTypeEndpoint(svc), s.OpAdmin, s.DecodeTypeReq, s.Encode))
+	r.Handle("/types/delete", handler(s.DeleteTypeEndpoint(svc), s.OpAdmin, s.DecodeTypeReq, s.Encode))
+
+	// File uploads
+	r.Methods("POST").Path("/upload").Handler(handler(s.UploadEndpoint(svc), s.OpCreate, s.DecodeUploadReq, s.EncodeREST))
+	r.Methods("POST").Path("/upload/session").Handler(handler(s.StartUploadEndpoint(svc), s.OpCreate, s.DecodeStartUploadReq, s.EncodeREST))
+	r.Methods("GET", "HEAD").Path("/upload/{token}").Handler(handler(s.UploadStatusEndpoint(svc), s.OpCreate, s.DecodeUploadStatusReq, s.EncodeREST))
+	r.Methods("PATCH").Path("/upload/{token}").Handler(handler(s.UploadChunkEndpoint(svc), s.OpCreate, s.DecodeUploadChunkReq, s.EncodeREST))
+
+	// RESTful routes
+	r.Methods("GET").Path("/api/{language}/{type}/{slug}").Handler(handler(s.ReadEndpoint(svc), s.OpRead, s.DecodeRESTReadReq, s.EncodeREST))
+	r.Methods("PUT", "PATCH").Path("/api/{language}/{type}/{slug}").Handler(handler(s.UpdateEndpoint(svc), s.OpUpdate, s.DecodeRESTUpdateReq, s.EncodeREST))
+	r.Methods("POST").Path("/api/{language}/{type}/{slug}/rename").Handler(handler(s.RenameEndpoint(svc), s.OpUpdate, s.DecodeRESTRenameReq, s.EncodeREST))
+	r.Methods("GET").Path("/api/{language}/{type}/{slug}/translations").Handler(handler(s.TranslationsEndpoint(svc), s.OpRead, s.DecodeRESTTranslationsReq, s.EncodeREST))
+	r.Methods("POST").Path("/api/{language}/{type}/{slug}/translations").Handler(handler(s.TranslateEndpoint(svc), s.OpCreate, s.DecodeRESTTranslateReq, s.EncodeREST))
+	r.Methods("DELETE").Path("/api/{language}/{type}/{slug}").Handler(handler(s.DeleteEndpoint(svc), s.OpDelete, s.DecodeRESTDeleteReq, s.EncodeREST))
+	r.Methods("GET").Path("/api/{language}/{type}").Handler(handler(s.ListEndpoint(svc), s.OpRead, s.DecodeRESTListReq, s.EncodeREST))
+	r.Methods("POST").Path("/api/{language}/{type}").Handler(handler(s.CreateEndpoint(svc), s.OpCreate, s.DecodeRESTCreateReq, s.EncodeREST))
+
+	r.PathPrefix(s.DrivePrefix).Handler(http.StripPrefix(s.DrivePrefix, s.DriveHandler(storage, opts.Images)))
+
+	srv := &Server{opts: opts, svc: svc}
+	srv.handler = srv.track(r)
+	return srv, nil
+}
+
+// track counts the requests served by next until the server is closed
+func (srv *Server) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.mu.Lock()
+		if srv.closed {
+			srv.mu.Unlock()
+			http.Error(w, "Server closed", http.StatusServiceUnavailable)
+			return
+		}
+		srv.inflight.Add(1)
+		srv.mu.Unlock()
+		defer srv.inflight.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handler returns the http.Handler serving all routes
+func (srv *Server) Handler() http.Handler {
+	return srv.handler
+}
+
+// Service returns the underlying service
+func (srv *Server) Service() *s.Service {
+	return srv.svc
+}
+
+// Close waits for the requests in flight, then flushes the indexes and
+// closes the database
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	srv.closed = true
+	srv.mu.Unlock()
+	srv.inflight.Wait()
+	return srv.svc.Close()
+}
+
+// ListenAndServe serves requests on addr until SIGINT or SIGTERM is
+// received, then drains in-flight requests and closes the server.
+func (srv *Server) ListenAndServe(addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           srv.handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       srv.opts.ReadTimeout,
+		WriteTimeout:      srv.opts.WriteTimeout,
+		IdleTimeout:       srv.opts.IdleTimeout,
+	}
+
+	// Serve until the listener fails or a signal arrives
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+
+	select {
+	case err := <-errc:
+		srv.Close()
+		return err
+	case sig := <-sigc:
+		log.Printf("Received %s, shutting down", sig)
+	}
+
+	// Stop accepting connections and wait for in-flight requests
+	ctx, cancel := context.WithTimeout(context.Background(), srv.opts.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		// Cancel the contexts of the remaining requests and let their
+		// handlers return before the database is closed
+		server.Close()
+		srv.Close()
+		return err
+	}
+
+	// Flush indexes and close the database
+	return srv.Close()
+}
+
+// collectGarbage runs the maintenance command of Run
+func (srv *Server) collectGarbage(dryRun bool) error {
+	defer srv.Close()
+	resp, _ := srv.svc.CollectGarbage(context.Background(), &s.GCRequest{DryRun: dryRun})
+	if resp.Err != "" {
+		return errors.New(resp.Err)
+	}
+	action := "Removed"
+	if dryRun {
+		action = "Unreferenced"
+	}
+	for _, f := range resp.Files {
+		log.Printf("%s %s (%d bytes)", action, f.Key, f.Size)
+	}
+	log.Printf("%s %d files (%d bytes) and %d upload sessions", action, len(resp.Files), resp.Size, len(resp.Sessions))
+	return nil
+}
+
+// languages used by Run
+var languages []language.Tag
+
 // Languages supported
-func Languages(languages []language.Tag) {
-	s.Languages = languages
+func Languages(l []language.Tag) {
+	languages = l
 }
 
 // EnableLanguages parses csv list
@@ -24,44 +331,78 @@ func EnableLanguages(csv string) {
 	list := strings.Split(csv, ",")
 	for _, code := range list {
 		if l := lang.CodeToTag(code); l != language.Und {
-			s.Languages = append(s.Languages, l)
+			languages = append(languages, l)
 		}
 	}
 }
 
-// Run method should be called from main function
-func Run(port int) {
+// Run method can be called from a main function without flags of its own,
+// it parses the command line and serves on port. Use New to embed the CMS
+// in a program that handles its own configuration.
+func Run(port int) error {
 	// Parse command line parameters
-	var dbFile string
-	flag.StringVar(&dbFile, "dbFile", "db/cloudcms.db", "The database filename")
+	opts := Options{Languages: languages}
+	flag.StringVar(&opts.DBFile, "dbFile", "db/cloudcms.db", "The database filename")
+	flag.StringVar(&opts.IndexFile, "indexFile", "", "The index directory (in-memory if empty)")
+	flag.DurationVar(&opts.ReadTimeout, "readTimeout", 5*time.Minute, "Maximum duration for reading a request")
+	flag.DurationVar(&opts.WriteTimeout, "writeTimeout", 5*time.Minute, "Maximum duration for writing a response")
+	flag.DurationVar(&opts.IdleTimeout, "idleTimeout", 2*time.Minute, "Maximum keep-alive idle time")
+	flag.DurationVar(&opts.ShutdownTimeout, "shutdownTimeout", 30*time.Second, "Maximum time to drain requests on shutdown")
+	flag.DurationVar(&opts.CacheTTL, "cacheTTL", 5*time.Minute, "Expiration of cached responses (negative disables caching)")
+	flag.IntVar(&opts.MaxRevisions, "maxRevisions", s.DefaultMaxRevisions, "Revisions kept per item (negative keeps all)")
+	flag.StringVar(&opts.Storage.Driver, "storage", "local", "The file storage driver (local or s3)")
+	flag.StringVar(&opts.Storage.Root, "driveDir", "drive", "The directory of the local file storage")
+	flag.StringVar(&opts.Storage.Endpoint, "s3Endpoint", "", "The S3 endpoint (host:port)")
+	flag.StringVar(&opts.Storage.Region, "s3Region", "", "The S3 region")
+	flag.StringVar(&opts.Storage.Bucket, "s3Bucket", "", "The S3 bucket")
+	flag.BoolVar(&opts.Storage.UseSSL, "s3SSL", true, "Connect to S3 with TLS")
+	flag.StringVar(&opts.Images.CacheDir, "imageCache", "cache/images", "The directory caching image derivatives")
+	flag.BoolVar(&opts.Fallback, "fallback", false, "Serve missing items in fallback languages")
+	fallbacks := flag.String("fallbacks", "", "Fallback chains of languages, e.g. pt-BR:pt,en;hi:en")
+	imageSizes := flag.String("imageSizes", "", "Allowed image derivative sizes, e.g. 400x300,800x0")
+	apiKeys := flag.String("apiKeys", "", "JSON file of API keys, e.g. {\"<key>\": {\"sub\": \"alice\", \"role\": \"editor\"}}")
+	tokens := flag.Bool("tokens", false, "Accept bearer tokens signed with the secret of $TOKEN_SECRET")
+	allowAll := flag.Bool("allowAll", false, "Allow all requests without credentials (development only)")
+	gc := flag.Bool("gc", false, "Remove the unreferenced files and exit")
+	gcDryRun := flag.Bool("gcDryRun", false, "Report the unreferenced files and exit")
+	// Keep the credentials out of the process list
+	opts.Storage.AccessKey = os.Getenv("S3_ACCESS_KEY")
+	opts.Storage.SecretKey = os.Getenv("S3_SECRET_KEY")
+	secret := os.Getenv("TOKEN_SECRET")
 	flag.Parse()
 
-	// Using English as default language
-	if len(s.Languages) == 0 {
-		s.Languages = append(s.Languages, language.English)
+	// Requests are anonymous unless an authenticator is enabled
+	var authn s.Authenticators
+	if *apiKeys != "" {
+		keys, err := s.LoadAPIKeys(*apiKeys)
+		if err != nil {
+			return err
+		}
+		authn = append(authn, keys)
 	}
-
-	if err := s.Initialize(dbFile); err != nil {
-		log.Fatal(err.Error())
+	if *tokens {
+		authn = append(authn, s.HMACTokens{Secret: []byte(secret)})
+	}
+	if *allowAll {
+		authn = append(authn, s.AllowAll{})
 	}
-	if err := s.RebuildIndex(); err != nil {
-		log.Fatal(err.Error())
+	if len(authn) > 0 {
+		opts.Authenticator = authn
 	}
 
-	var svc s.Service
-	svc = s.Service{}
+	opts.Fallbacks = s.ParseFallbacks(*fallbacks)
 
-	r := mux.NewRouter()
-	r.Handle("/create", h.NewServer(s.CreateEndpoint(svc), s.DecodeCreateReq, s.Encode))
-	r.Handle("/read", h.NewServer(s.ReadEndpoint(svc), s.DecodeReadReq, s.Encode))
-	r.Handle("/update", h.NewServer(s.UpdateEndpoint(svc), s.DecodeUpdateReq, s.Encode))
-	r.Handle("/delete", h.NewServer(s.DeleteEndpoint(svc), s.DecodeDeleteReq, s.Encode))
-	r.Handle("/search", h.NewServer(s.SearchEndpoint(svc), s.DecodeSearchReq, s.Encode))
-	r.Handle("/facets", h.NewServer(s.FacetsSearchEndpoint(svc), s.DecodeFacetsSearchReq, s.Encode))
-	r.Handle("/list", h.NewServer(s.ListEndpoint(svc), s.DecodeListReq, s.Encode))
-	r.Handle("/schema", h.NewServer(s.SchemaEndpoint(svc), s.DecodeSchemaReq, s.Encode))
-
-	r.PathPrefix("/drive/").Handler(http.StripPrefix("/drive/", http.FileServer(http.Dir("drive"))))
-
-	http.ListenAndServe(fmt.Sprintf(":%d", port), r)
+	var err error
+	if opts.Images.Sizes, err = s.ParseImageSizes(*imageSizes); err != nil {
+		return err
+	}
+
+	srv, err := New(opts)
+	if err != nil {
+		return err
+	}
+	if *gc || *gcDryRun {
+		return srv.collectGarbage(*gcDryRun)
+	}
+	return srv.ListenAndServe(fmt.Sprintf(":%d", port))
 }
@@ -0,0 +1,103 @@
+package lightcms
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	s "git.urantiatech.com/cloudcms/lightcms/service"
+	"golang.org/x/text/language"
+)
+
+// newTestServer creates a server storing everything in a temporary directory
+func newTestServer(t *testing.T, opts Options) *Server {
+	dir := t.TempDir()
+	opts.DBFile = filepath.Join(dir, "cms.db")
+	opts.UploadDir = filepath.Join(dir, "uploads")
+	opts.Storage = s.StorageConfig{Root: filepath.Join(dir, "drive")}
+	srv, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func TestCloseWaitsForRequests(t *testing.T) {
+	srv := newTestServer(t, Options{})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := srv.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/read", nil))
+	<-started
+
+	closed := make(chan error)
+	go func() { closed <- srv.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a request was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests arriving after Close are refused
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/read", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d after Close, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestNew(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	if !reflect.DeepEqual(srv.opts.Languages, []language.Tag{language.English}) {
+		t.Errorf("languages %v, want English", srv.opts.Languages)
+	}
+	if srv.opts.ShutdownTimeout != 30*time.Second || srv.opts.SchedulerInterval != time.Minute {
+		t.Errorf("timeouts %v, %v", srv.opts.ShutdownTimeout, srv.opts.SchedulerInterval)
+	}
+
+	// Servers in one process don't share their state
+	other := newTestServer(t, Options{Languages: []language.Tag{language.Hindi}})
+	ctx := context.Background()
+	if resp, _ := srv.Service().CreateType(ctx, &s.TypeDefinition{Name: "note"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if resp, _ := other.Service().CreateType(ctx, &s.TypeDefinition{Name: "note"}); resp.Err != "" {
+		t.Errorf("type of other server: %s", resp.Err)
+	}
+
+	// The database is owned by a single server
+	if _, err := New(Options{DBFile: srv.opts.DBFile, Storage: srv.opts.Storage}); err == nil {
+		t.Error("database opened by two servers")
+	}
+
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name string
+		opts Options
+		err  error
+	}{
+		{"token secret", Options{Authenticator: s.Authenticators{s.HMACTokens{}}}, s.ErrorEmptySecret},
+		{"storage", Options{Storage: s.StorageConfig{Driver: "ftp"}}, s.ErrorInvalidStorage},
+	} {
+		tt.opts.DBFile = filepath.Join(dir, tt.name+".db")
+		if srv, err := New(tt.opts); err != tt.err {
+			if srv != nil {
+				srv.Close()
+			}
+			t.Errorf("New() with invalid %s: error %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package lightcms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	s "git.urantiatech.com/cloudcms/lightcms/service"
+)
+
+func TestRESTRoutes(t *testing.T) {
+	srv := newTestServer(t, Options{Authenticator: s.AllowAll{}})
+	if resp, _ := srv.Service().CreateType(context.Background(), &s.TypeDefinition{Name: "note"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+		want   map[string]interface{}
+	}{
+		// The path sets the type and language of the body
+		{"POST", "/api/en/note", `{"type": "other", "slug": "a", "content": {"title": "A"}}`, http.StatusOK,
+			map[string]interface{}{"slug": "a", "version": 1.0}},
+		{"POST", "/api/en/note", `{"slug": "a", "content": {"title": "A"}}`, http.StatusConflict, nil},
+		{"POST", "/api/en/note", `{"slug": `, http.StatusBadRequest, nil},
+		{"POST", "/api/en/page", `{"slug": "a", "content": {"title": "A"}}`, http.StatusNotFound, nil},
+
+		{"GET", "/api/en/note/a", "", http.StatusOK, map[string]interface{}{"title": "A"}},
+		{"GET", "/api/en/note/b", "", http.StatusNotFound, nil},
+		{"GET", "/api/xx/note/a", "", http.StatusNotFound, nil},
+
+		{"PUT", "/api/en/note/a", `{"slug": "b", "content": {"title": "B", "version": 1}}`, http.StatusOK,
+			map[string]interface{}{"slug": "a", "title": "B", "version": 2.0}},
+		{"PATCH", "/api/en/note/a", `{"content": {"title": "C", "version": 1}}`, http.StatusConflict,
+			map[string]interface{}{"title": "B"}},
+		{"PUT", "/api/en/note/b", `{"content": {"title": "B"}}`, http.StatusNotFound, nil},
+
+		{"GET", "/api/en/note?status=draft&size=5", "", http.StatusOK, map[string]interface{}{"total": 1}},
+		{"GET", "/api/en/note?status=published", "", http.StatusOK, map[string]interface{}{"total": 0}},
+		{"GET", "/api/en/note?size=five", "", http.StatusBadRequest, nil},
+
+		{"DELETE", "/api/en/note/a?version=1", "", http.StatusConflict, nil},
+		{"DELETE", "/api/en/note/a", "", http.StatusOK, nil},
+		{"DELETE", "/api/en/note/a", "", http.StatusNotFound, nil},
+		{"GET", "/api/en/note/a", "", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, w.Code, tt.status, w.Body)
+			continue
+		}
+		if tt.want == nil {
+			continue
+		}
+
+		// Lists report their total, items are checked field by field
+		if total, ok := tt.want["total"]; ok {
+			var list api.ListResults
+			if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || list.Total != uint64(total.(int)) {
+				t.Errorf("%s %s: total %d, %v, want %v", tt.method, tt.path, list.Total, err, total)
+			}
+			continue
+		}
+		var resp api.Response
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s %s: %v", tt.method, tt.path, err)
+			continue
+		}
+		if resp.Type != "note" || resp.Language != "en" {
+			t.Errorf("%s %s: item of %s/%s", tt.method, tt.path, resp.Language, resp.Type)
+		}
+		content, _ := resp.Content.(map[string]interface{})
+		for k, v := range tt.want {
+			if content[k] != v {
+				t.Errorf("%s %s: %s = %v, want %v", tt.method, tt.path, k, content[k], v)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+
+	"git.urantiatech.com/cloudcms/cloudcms/item"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+
+	// Register the analyzers of field overrides and languages
+	_ "github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	_ "github.com/blevesearch/bleve/analysis/analyzer/simple"
+	_ "github.com/blevesearch/bleve/analysis/lang/ar"
+	_ "github.com/blevesearch/bleve/analysis/lang/cjk"
+	_ "github.com/blevesearch/bleve/analysis/lang/ckb"
+	_ "github.com/blevesearch/bleve/analysis/lang/da"
+	_ "github.com/blevesearch/bleve/analysis/lang/de"
+	_ "github.com/blevesearch/bleve/analysis/lang/en"
+	_ "github.com/blevesearch/bleve/analysis/lang/es"
+	_ "github.com/blevesearch/bleve/analysis/lang/fa"
+	_ "github.com/blevesearch/bleve/analysis/lang/fi"
+	_ "github.com/blevesearch/bleve/analysis/lang/fr"
+	_ "github.com/blevesearch/bleve/analysis/lang/hi"
+	_ "github.com/blevesearch/bleve/analysis/lang/hu"
+	_ "github.com/blevesearch/bleve/analysis/lang/it"
+	_ "github.com/blevesearch/bleve/analysis/lang/nl"
+	_ "github.com/blevesearch/bleve/analysis/lang/no"
+	_ "github.com/blevesearch/bleve/analysis/lang/pt"
+	_ "github.com/blevesearch/bleve/analysis/lang/ro"
+	_ "github.com/blevesearch/bleve/analysis/lang/ru"
+	_ "github.com/blevesearch/bleve/analysis/lang/sv"
+	_ "github.com/blevesearch/bleve/analysis/lang/tr"
+)
+
+// signatureKey is the internal index key holding the signature of the
+// mapping the index was created with, bleve keeps the mapping in "_mapping"
+var signatureKey = []byte("_signature")
+
+// languageAnalyzers maps languages to the bleve analyzer of their text,
+// other languages use the standard analyzer
+var languageAnalyzers = map[string]string{
+	"ar": "ar", "ckb": "ckb", "da": "da", "de": "de", "en": "en",
+	"es": "es", "fa": "fa", "fi": "fi", "fr": "fr", "hi": "hi",
+	"hu": "hu", "it": "it", "nl": "nl", "no": "no", "nb": "no",
+	"nn": "no", "pt": "pt", "ro": "ro", "ru": "ru", "sv": "sv",
+	"tr": "tr", "zh": "cjk", "ja": "cjk", "ko": "cjk",
+}
+
+// keywordFields are indexed verbatim whatever the language, they are
+// matched exactly by filters
+var keywordFields = []string{"status", "slug", "language", "translation_group"}
+
+// newIndexMapping returns the mapping of the index of content type and
+// language
+func (s *Service) newIndexMapping(contentType, language string) (mapping.IndexMapping, error) {
+	return indexMapping(language, s.fieldsOf(contentType))
+}
+
+// indexMapping returns the mapping of an index of language for a content
+// type with fields. Text is analyzed for language unless the field
+// definition or the content mapping names an analyzer, keywordFields are
+// never analyzed.
+func indexMapping(language string, fields []Field) (mapping.IndexMapping, error) {
+	m := bleve.NewIndexMapping()
+	if analyzer, ok := languageAnalyzers[baseLanguage(language)]; ok {
+		m.DefaultAnalyzer = analyzer
+	}
+
+	var overrides []Field
+	for _, f := range fields {
+		if f.Analyzer != "" {
+			overrides = append(overrides, f)
+		}
+	}
+
+	// Copy the shared content mapping before adding the overrides
+	doc := bleve.NewDocumentMapping()
+	if item.ContentMapping != nil {
+		j, err := json.Marshal(item.ContentMapping)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(j, doc); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range overrides {
+		field := bleve.NewTextFieldMapping()
+		field.Analyzer = f.Analyzer
+		property := bleve.NewDocumentMapping()
+		property.AddFieldMapping(field)
+		doc.AddSubDocumentMapping(f.Name, property)
+	}
+	for _, name := range keywordFields {
+		field := bleve.NewTextFieldMapping()
+		field.Analyzer = "keyword"
+		property := bleve.NewDocumentMapping()
+		property.AddFieldMapping(field)
+		doc.AddSubDocumentMapping(name, property)
+	}
+	m.DefaultMapping = doc
+	return m, nil
+}
+
+// mappingSignature identifies a mapping, indexes are rebuilt when the
+// signature of their mapping changes
+func mappingSignature(m mapping.IndexMapping) ([]byte, error) {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(j)
+	return sum[:], nil
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	q "github.com/blevesearch/bleve/search/query"
+)
+
+func TestIndexMappingKeywords(t *testing.T) {
+	m, err := indexMapping("en", []Field{{Name: "title", Type: "text"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := bleve.NewMemOnly(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	docs := map[string]map[string]interface{}{
+		"1": {"status": StatusReview, "slug": "the-reviews", "language": "en", "translation_group": "en-1", "title": "Reviews in review"},
+		"2": {"status": StatusPublished, "slug": "review", "language": "en", "translation_group": "en-2", "title": "Published reviews"},
+		"3": {"status": StatusDraft, "slug": "publishing", "language": "en-GB", "translation_group": "hi-1", "title": "Draft"},
+	}
+	for id, doc := range docs {
+		if err := index.Index(id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	term := func(field, value string) q.Query {
+		query := bleve.NewTermQuery(value)
+		query.SetField(field)
+		return query
+	}
+	tests := []struct {
+		name  string
+		query q.Query
+		want  string
+	}{
+		{"status in review", statusQuery(StatusReview), "1"},
+		{"status published", statusQuery(StatusPublished), "2"},
+		{"status term", term("status", StatusReview), "1"},
+		{"whole slug", term("slug", "the-reviews"), "1"},
+		{"slug not stemmed", term("slug", "publishing"), "3"},
+		{"regional language", term("language", "en-GB"), "3"},
+		{"translation group", term("translation_group", "hi-1"), "3"},
+	}
+	for _, tt := range tests {
+		res, err := index.Search(bleve.NewSearchRequest(tt.query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Hits) != 1 || res.Hits[0].ID != tt.want {
+			var ids []string
+			for _, hit := range res.Hits {
+				ids = append(ids, hit.ID)
+			}
+			t.Errorf("%s: hits %v, want [%s]", tt.name, ids, tt.want)
+		}
+	}
+}
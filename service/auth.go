@@ -0,0 +1,329 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	h "github.com/go-kit/kit/transport/http"
+)
+
+// Authentication and authorization errors
+var (
+	ErrorUnauthorized = errors.New("Unauthorized")
+	ErrorForbidden    = errors.New("Forbidden")
+	ErrorInvalidToken = errors.New("Invalid token")
+	ErrorInvalidRole  = errors.New("Invalid role")
+	ErrorEmptySecret  = errors.New("Empty token secret")
+)
+
+// Role of a user, a role includes all permissions of the lower roles
+type Role int
+
+// Roles
+const (
+	RoleAnonymous Role = iota
+	RoleReader
+	RoleEditor
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleAnonymous: "anonymous",
+	RoleReader:    "reader",
+	RoleEditor:    "editor",
+	RoleAdmin:     "admin",
+}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+// MarshalText encodes the role by name
+func (r Role) MarshalText() ([]byte, error) {
+	if _, ok := roleNames[r]; !ok {
+		return nil, ErrorInvalidRole
+	}
+	return []byte(roleNames[r]), nil
+}
+
+// UnmarshalText decodes the role by name
+func (r *Role) UnmarshalText(text []byte) error {
+	for role, name := range roleNames {
+		if name == string(text) {
+			*r = role
+			return nil
+		}
+	}
+	return ErrorInvalidRole
+}
+
+// Operation performed on content
+type Operation string
+
+// Operations
+const (
+	OpRead    Operation = "read"
+	OpCreate  Operation = "create"
+	OpUpdate  Operation = "update"
+	OpDelete  Operation = "delete"
+	OpTrash   Operation = "trash"
+	OpPurge   Operation = "purge"
+	OpHistory Operation = "history"
+	OpAdmin   Operation = "admin"
+)
+
+// User is an authenticated caller
+type User struct {
+	Name string `json:"sub"`
+	Role Role   `json:"role"`
+}
+
+// Authenticator identifies the user of a request. It returns nil user if
+// the request carries no credentials it understands.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*User, error)
+}
+
+// Authenticators tries each authenticator in order
+type Authenticators []Authenticator
+
+// Authenticate returns the user of the first authenticator recognizing the request
+func (a Authenticators) Authenticate(r *http.Request) (*User, error) {
+	for _, authn := range a {
+		user, err := authn.Authenticate(r)
+		if err != nil || user != nil {
+			return user, err
+		}
+	}
+	return nil, nil
+}
+
+// APIKeys authenticates the X-API-Key header, keyed by API key
+type APIKeys map[string]User
+
+// Authenticate looks up the API key of the request
+func (k APIKeys) Authenticate(r *http.Request) (*User, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return nil, nil
+	}
+	for known, user := range k {
+		if hmac.Equal([]byte(known), []byte(key)) {
+			u := user
+			return &u, nil
+		}
+	}
+	return nil, ErrorUnauthorized
+}
+
+// LoadAPIKeys reads API keys from a JSON file mapping each key to its user,
+// e.g. {"<key>": {"sub": "alice", "role": "editor"}}
+func LoadAPIKeys(name string) (APIKeys, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var keys APIKeys
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// HMACTokens authenticates "Authorization: Bearer <token>" headers carrying
+// tokens signed by Sign with the same secret, which must not be empty
+type HMACTokens struct {
+	Secret []byte
+}
+
+type tokenClaims struct {
+	User
+	Expires int64 `json:"exp"`
+}
+
+// Sign issues a token for user valid for ttl
+func (t HMACTokens) Sign(user User, ttl time.Duration) (string, error) {
+	if len(t.Secret) == 0 {
+		return "", ErrorEmptySecret
+	}
+	payload, err := json.Marshal(tokenClaims{User: user, Expires: time.Now().Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	p := base64.RawURLEncoding.EncodeToString(payload)
+	return p + "." + base64.RawURLEncoding.EncodeToString(t.mac(p)), nil
+}
+
+// Authenticate verifies the bearer token of the request
+func (t HMACTokens) Authenticate(r *http.Request) (*User, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(auth, "Bearer "), ".")
+	if len(parts) != 2 || len(t.Secret) == 0 {
+		return nil, ErrorInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, t.mac(parts[0])) {
+		return nil, ErrorInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrorInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrorInvalidToken
+	}
+	if time.Now().Unix() > claims.Expires {
+		return nil, ErrorInvalidToken
+	}
+	return &claims.User, nil
+}
+
+func (t HMACTokens) mac(payload string) []byte {
+	m := hmac.New(sha256.New, t.Secret)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+// Policy defines the minimum role required for each operation, Types
+// overrides Default for individual content types
+type Policy struct {
+	Default map[Operation]Role
+	Types   map[string]map[Operation]Role
+}
+
+// DefaultPolicy lets anyone read, editors create and update, admins delete
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Default: map[Operation]Role{
+			OpRead:    RoleAnonymous,
+			OpCreate:  RoleEditor,
+			OpUpdate:  RoleEditor,
+			OpDelete:  RoleAdmin,
+			OpTrash:   RoleEditor,
+			OpPurge:   RoleAdmin,
+			OpHistory: RoleEditor,
+			OpAdmin:   RoleAdmin,
+		},
+	}
+}
+
+// Required returns the minimum role for operation on content type
+func (p *Policy) Required(contentType string, op Operation) Role {
+	if roles, ok := p.Types[contentType]; ok {
+		if role, ok := roles[op]; ok {
+			return role
+		}
+	}
+	if role, ok := p.Default[op]; ok {
+		return role
+	}
+	return RoleAdmin
+}
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	authErrKey
+	conditionsKey
+	versionKey
+)
+
+// UserFromContext returns the authenticated user, nil if anonymous
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userKey).(*User)
+	return user
+}
+
+// WithUser returns a context authenticated as user
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// CheckAuthenticator rejects authenticators that would accept forged
+// credentials, such as HMACTokens without a secret
+func CheckAuthenticator(authn Authenticator) error {
+	switch a := authn.(type) {
+	case Authenticators:
+		for _, authn := range a {
+			if err := CheckAuthenticator(authn); err != nil {
+				return err
+			}
+		}
+	case HMACTokens:
+		if len(a.Secret) == 0 {
+			return ErrorEmptySecret
+		}
+	case *HMACTokens:
+		if a == nil || len(a.Secret) == 0 {
+			return ErrorEmptySecret
+		}
+	}
+	return nil
+}
+
+// AllowAll authenticates every request as an anonymous admin. It must be
+// configured explicitly, e.g. for development.
+type AllowAll struct{}
+
+// Authenticate returns an anonymous admin
+func (AllowAll) Authenticate(r *http.Request) (*User, error) {
+	return &User{Role: RoleAdmin}, nil
+}
+
+// Authenticate returns a go-kit request function storing the user of
+// the request in the context
+func Authenticate(authn Authenticator) h.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		user, err := authn.Authenticate(r)
+		if err != nil {
+			return context.WithValue(ctx, authErrKey, err)
+		}
+		if user != nil {
+			ctx = WithUser(ctx, user)
+		}
+		return ctx
+	}
+}
+
+// Authorize returns an endpoint middleware rejecting users whose role is
+// below the one required by policy for op on the requested content type
+func Authorize(policy *Policy, op Operation) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if err, ok := ctx.Value(authErrKey).(error); ok {
+				return nil, err
+			}
+			role := RoleAnonymous
+			user := UserFromContext(ctx)
+			if user != nil {
+				role = user.Role
+			}
+			if role < policy.Required(contentTypeOf(request), op) {
+				if user == nil {
+					return nil, ErrorUnauthorized
+				}
+				return nil, ErrorForbidden
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// contentTypeOf returns the Type field of a request
+func contentTypeOf(request interface{}) string {
+	return stringField(request, "Type")
+}
@@ -0,0 +1,159 @@
+package service_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	s "git.urantiatech.com/cloudcms/lightcms/service"
+)
+
+func authenticate(authn s.Authenticator, header, value string) (*s.User, error) {
+	r := httptest.NewRequest("GET", "/read", nil)
+	if header != "" {
+		r.Header.Set(header, value)
+	}
+	return authn.Authenticate(r)
+}
+
+func TestHMACTokens(t *testing.T) {
+	tokens := s.HMACTokens{Secret: []byte("secret")}
+	alice := s.User{Name: "alice", Role: s.RoleEditor}
+
+	token, err := tokens.Sign(alice, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		user, err := authenticate(tokens, "Authorization", "Bearer "+token)
+		if err != nil || user == nil || *user != alice {
+			t.Errorf("Authenticate() = %v, %v, want %v", user, err, alice)
+		}
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		if user, err := authenticate(tokens, "", ""); user != nil || err != nil {
+			t.Errorf("Authenticate() = %v, %v, want anonymous", user, err)
+		}
+		if user, err := authenticate(tokens, "Authorization", "Basic YTpi"); user != nil || err != nil {
+			t.Errorf("Authenticate() of basic auth = %v, %v, want anonymous", user, err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expired, err := tokens.Sign(alice, -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := authenticate(tokens, "Authorization", "Bearer "+expired); err != s.ErrorInvalidToken {
+			t.Errorf("Authenticate() error = %v, want %v", err, s.ErrorInvalidToken)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		parts := strings.Split(token, ".")
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		forged := strings.Replace(string(payload), `"editor"`, `"admin"`, 1)
+		if forged == string(payload) {
+			t.Fatalf("role not found in payload %s", payload)
+		}
+
+		for name, token := range map[string]string{
+			"payload":   base64.RawURLEncoding.EncodeToString([]byte(forged)) + "." + parts[1],
+			"signature": parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte("forged")),
+			"unsigned":  parts[0],
+			"malformed": parts[0] + ".!!",
+		} {
+			if user, err := authenticate(tokens, "Authorization", "Bearer "+token); err != s.ErrorInvalidToken {
+				t.Errorf("%s: Authenticate() = %v, %v, want %v", name, user, err, s.ErrorInvalidToken)
+			}
+		}
+
+		other := s.HMACTokens{Secret: []byte("other")}
+		if _, err := authenticate(other, "Authorization", "Bearer "+token); err != s.ErrorInvalidToken {
+			t.Errorf("token of other secret: error %v, want %v", err, s.ErrorInvalidToken)
+		}
+	})
+
+	t.Run("empty secret", func(t *testing.T) {
+		empty := s.HMACTokens{}
+		if _, err := empty.Sign(alice, time.Hour); err != s.ErrorEmptySecret {
+			t.Errorf("Sign() error = %v, want %v", err, s.ErrorEmptySecret)
+		}
+		if _, err := authenticate(empty, "Authorization", "Bearer "+token); err != s.ErrorInvalidToken {
+			t.Errorf("Authenticate() error = %v, want %v", err, s.ErrorInvalidToken)
+		}
+		if err := s.CheckAuthenticator(s.Authenticators{s.APIKeys{}, empty}); err != s.ErrorEmptySecret {
+			t.Errorf("CheckAuthenticator() = %v, want %v", err, s.ErrorEmptySecret)
+		}
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	keys := s.APIKeys{"k1": {Name: "bob", Role: s.RoleReader}}
+	if user, err := authenticate(keys, "X-API-Key", "k1"); err != nil || user == nil || user.Name != "bob" {
+		t.Errorf("known key: %v, %v", user, err)
+	}
+	if _, err := authenticate(keys, "X-API-Key", "k2"); err != s.ErrorUnauthorized {
+		t.Errorf("unknown key: error %v, want %v", err, s.ErrorUnauthorized)
+	}
+
+	// The first authenticator recognizing the request is used
+	authn := s.Authenticators{keys, s.AllowAll{}}
+	if user, _ := authenticate(authn, "X-API-Key", "k1"); user == nil || user.Role != s.RoleReader {
+		t.Errorf("Authenticators with key: %v", user)
+	}
+	if user, _ := authenticate(authn, "", ""); user == nil || user.Role != s.RoleAdmin {
+		t.Errorf("Authenticators without key: %v", user)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	policy := s.DefaultPolicy()
+	policy.Types = map[string]map[s.Operation]s.Role{
+		"secret": {s.OpRead: s.RoleReader},
+	}
+	next := func(ctx context.Context, request interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	tests := []struct {
+		name    string
+		user    *s.User
+		op      s.Operation
+		request interface{}
+		err     error
+	}{
+		{"anonymous read", nil, s.OpRead, &api.ReadRequest{Type: "article"}, nil},
+		{"anonymous write", nil, s.OpCreate, &api.CreateRequest{Type: "article"}, s.ErrorUnauthorized},
+		{"reader write", &s.User{Role: s.RoleReader}, s.OpUpdate, &api.UpdateRequest{Type: "article"}, s.ErrorForbidden},
+		{"editor write", &s.User{Role: s.RoleEditor}, s.OpUpdate, &api.UpdateRequest{Type: "article"}, nil},
+		{"editor delete", &s.User{Role: s.RoleEditor}, s.OpDelete, &api.DeleteRequest{Type: "article"}, s.ErrorForbidden},
+		{"admin delete", &s.User{Role: s.RoleAdmin}, s.OpDelete, &api.DeleteRequest{Type: "article"}, nil},
+		{"anonymous read of type", nil, s.OpRead, &api.ReadRequest{Type: "secret"}, s.ErrorUnauthorized},
+		{"reader read of type", &s.User{Role: s.RoleReader}, s.OpRead, &api.ReadRequest{Type: "secret"}, nil},
+		{"unknown operation", &s.User{Role: s.RoleEditor}, s.Operation("export"), &api.ReadRequest{Type: "article"}, s.ErrorForbidden},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.user != nil {
+			ctx = s.WithUser(ctx, tt.user)
+		}
+		if _, err := s.Authorize(policy, tt.op)(next)(ctx, tt.request); err != tt.err {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// Invalid credentials are rejected even for anonymous operations
+	r := httptest.NewRequest("GET", "/read", nil)
+	r.Header.Set("Authorization", "Bearer forged.token")
+	ctx := s.Authenticate(s.HMACTokens{Secret: []byte("secret")})(context.Background(), r)
+	if _, err := s.Authorize(policy, s.OpRead)(next)(ctx, &api.ReadRequest{Type: "article"}); err != s.ErrorInvalidToken {
+		t.Errorf("invalid token: error %v, want %v", err, s.ErrorInvalidToken)
+	}
+}
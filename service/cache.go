@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/patrickmn/go-cache"
+)
+
+// DefaultCacheTTL is the expiration of cached responses if CacheTTL is zero
+const DefaultCacheTTL = 5 * time.Minute
+
+// CacheStats reports the effectiveness of the response cache
+type CacheStats struct {
+	Enabled bool   `json:"enabled"`
+	TTL     string `json:"ttl"`
+	Items   int    `json:"items"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Err     string `json:"error,omitempty"`
+}
+
+// respCache caches the responses of read requests. Every write bumps the
+// generation of its content type and language so the keys of all cached
+// responses change, stale entries expire with their TTL.
+type respCache struct {
+	items *cache.Cache
+	ttl   time.Duration
+
+	mu          sync.Mutex
+	generations map[string]uint64
+	hits        uint64
+	misses      uint64
+}
+
+// newRespCache creates a cache, caching is disabled if ttl is negative
+func newRespCache(ttl time.Duration) *respCache {
+	if ttl == 0 {
+		ttl = DefaultCacheTTL
+	}
+	c := &respCache{ttl: ttl, generations: make(map[string]uint64)}
+	if ttl > 0 {
+		c.items = cache.New(ttl, 2*ttl)
+	}
+	return c
+}
+
+// key derives the cache key of req reading content of languages, public
+// and editor requests see different content so they are cached separately
+func (c *respCache) key(ctx context.Context, op, contentType string, req interface{}, languages ...string) string {
+	j, err := json.Marshal(req)
+	if err != nil {
+		return ""
+	}
+	visibility := "editor"
+	if isPublic(ctx) {
+		visibility = "public"
+	}
+
+	// A write to any of the languages changes the key
+	var gens []string
+	c.mu.Lock()
+	for _, l := range languages {
+		gens = append(gens, fmt.Sprintf("%s.%d", l, c.generations[contentType+"."+l]))
+	}
+	c.mu.Unlock()
+
+	return fmt.Sprintf("%s.%s.%s.%s.%x", strings.Join(gens, ","), contentType, op, visibility, sha256.Sum256(j))
+}
+
+// get returns the cached response of key
+func (c *respCache) get(key string) (interface{}, bool) {
+	if c.items == nil || key == "" {
+		return nil, false
+	}
+	v, ok := c.items.Get(key)
+
+	c.mu.Lock()
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.mu.Unlock()
+	return v, ok
+}
+
+// set caches the response of key
+func (c *respCache) set(key string, v interface{}) {
+	if c.items == nil || key == "" {
+		return
+	}
+	c.items.Set(key, v, cache.DefaultExpiration)
+}
+
+// invalidate drops the cached responses of content type and language
+func (c *respCache) invalidate(contentType, language string) {
+	c.mu.Lock()
+	c.generations[contentType+"."+language]++
+	c.mu.Unlock()
+}
+
+// stats returns the cache statistics
+func (c *respCache) stats() *CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := CacheStats{Enabled: c.items != nil, Hits: c.hits, Misses: c.misses}
+	if c.items != nil {
+		stats.TTL = c.ttl.String()
+		stats.Items = c.items.ItemCount()
+	}
+	return &stats
+}
+
+// CacheStats - returns the hit and miss counts of the response cache
+func (s *Service) CacheStats(ctx context.Context) (*CacheStats, error) {
+	return s.cache.stats(), nil
+}
+
+// CacheStatsEndpoint - creates endpoint for CacheStats service
+func CacheStatsEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		return svc.CacheStats(ctx)
+	}
+}
+
+// DecodeCacheStatsReq - decodes the incoming request
+func DecodeCacheStatsReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	return nil, nil
+}
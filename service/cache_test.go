@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheInvalidate(t *testing.T) {
+	type write struct{ contentType, language string }
+	tests := []struct {
+		name      string
+		languages []string
+		writes    []write
+		hit       bool
+	}{
+		{"no write", []string{"en"}, nil, true},
+		{"write to language", []string{"en"}, []write{{"article", "en"}}, false},
+		{"write to other language", []string{"en"}, []write{{"article", "hi"}}, true},
+		{"write to other type", []string{"en"}, []write{{"page", "en"}}, true},
+		{"write to fallback language", []string{"hi", "en"}, []write{{"article", "en"}}, false},
+		{"write outside fallback chain", []string{"hi", "en"}, []write{{"article", "fr"}}, true},
+		{"several writes", []string{"en"}, []write{{"page", "en"}, {"article", "en"}}, false},
+	}
+	for _, tt := range tests {
+		c := newRespCache(time.Minute)
+		ctx := context.Background()
+		req := map[string]string{"slug": "hello"}
+
+		key := c.key(ctx, "read", "article", req, tt.languages...)
+		c.set(key, "cached")
+		for _, w := range tt.writes {
+			c.invalidate(w.contentType, w.language)
+		}
+		_, hit := c.get(c.key(ctx, "read", "article", req, tt.languages...))
+		if hit != tt.hit {
+			t.Errorf("%s: hit = %v, want %v", tt.name, hit, tt.hit)
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	c := newRespCache(time.Minute)
+	public := context.Background()
+	editor := WithUser(public, &User{Name: "e", Role: RoleEditor})
+	req := map[string]string{"slug": "hello"}
+
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"same request", c.key(public, "read", "article", req, "en"), c.key(public, "read", "article", req, "en"), true},
+		{"public and editor", c.key(public, "read", "article", req, "en"), c.key(editor, "read", "article", req, "en"), false},
+		{"operation", c.key(public, "read", "article", req, "en"), c.key(public, "list", "article", req, "en"), false},
+		{"content type", c.key(public, "read", "article", req, "en"), c.key(public, "read", "page", req, "en"), false},
+		{"language", c.key(public, "read", "article", req, "en"), c.key(public, "read", "article", req, "hi"), false},
+		{"request", c.key(public, "read", "article", req, "en"), c.key(public, "read", "article", map[string]string{"slug": "bye"}, "en"), false},
+	}
+	for _, tt := range tests {
+		if (tt.a == tt.b) != tt.same {
+			t.Errorf("%s: keys %q and %q", tt.name, tt.a, tt.b)
+		}
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c := newRespCache(-1)
+	key := c.key(context.Background(), "read", "article", "hello", "en")
+	c.set(key, "cached")
+	if _, hit := c.get(key); hit {
+		t.Error("disabled cache returned a response")
+	}
+	if stats := c.stats(); stats.Enabled {
+		t.Error("disabled cache reported as enabled")
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	h "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+)
+
+// ErrorPreconditionFailed is returned if the If-Match header of an update
+// doesn't match the current item
+var ErrorPreconditionFailed = errors.New("Precondition failed")
+
+// conditions are the conditional headers of a request
+type conditions struct {
+	op              Operation
+	method          string
+	ifMatch         string
+	ifNoneMatch     string
+	ifModifiedSince time.Time
+	path            string
+	slug            string
+}
+
+// Conditions returns a go-kit ServerBefore hook keeping the conditional
+// headers and the expected version of requests for operation op
+func Conditions(op Operation) h.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		c := conditions{
+			op:          op,
+			method:      r.Method,
+			ifMatch:     r.Header.Get("If-Match"),
+			ifNoneMatch: r.Header.Get("If-None-Match"),
+			path:        r.URL.Path,
+			slug:        mux.Vars(r)["slug"],
+		}
+		if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+			c.ifModifiedSince = t
+		}
+		// Requests without content pass the expected version in the query
+		if v, err := strconv.ParseInt(r.URL.Query().Get("version"), 10, 64); err == nil {
+			ctx = WithVersion(ctx, v)
+		}
+		return context.WithValue(ctx, conditionsKey, &c)
+	}
+}
+
+func conditionsFromContext(ctx context.Context) *conditions {
+	c, _ := ctx.Value(conditionsKey).(*conditions)
+	return c
+}
+
+// contentETag is the entity tag of an item
+func contentETag(content interface{}) string {
+	j, err := json.Marshal(content)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(j)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// updatedAt returns the updated_at time of an item
+func updatedAt(content interface{}) time.Time {
+	item, _ := content.(map[string]interface{})
+	if t, ok := toInt64(item["updated_at"]); ok && t > 0 {
+		return time.Unix(t, 0)
+	}
+	return time.Time{}
+}
+
+// validators returns the entity tag and last modification time of a
+// successful response, the tag is empty for other responses
+func validators(response interface{}) (string, time.Time) {
+	var items, body interface{}
+
+	switch r := response.(type) {
+	case *api.Response:
+		if r.Err != "" || r.Content == nil {
+			return "", time.Time{}
+		}
+		return contentETag(r.Content), updatedAt(r.Content)
+	case *api.ListResults:
+		if r.Err != "" {
+			return "", time.Time{}
+		}
+		items, body = r.List, []interface{}{r.Total, r.List}
+	case *api.SearchResults:
+		if r.Err != "" {
+			return "", time.Time{}
+		}
+		items, body = r.Hits, []interface{}{r.Total, r.Hits}
+	default:
+		return "", time.Time{}
+	}
+
+	// Lists were modified when their latest item was
+	var modified time.Time
+	list := reflect.ValueOf(items)
+	for i := 0; list.Kind() == reflect.Slice && i < list.Len(); i++ {
+		if t := updatedAt(list.Index(i).Interface()); t.After(modified) {
+			modified = t
+		}
+	}
+	return contentETag(body), modified
+}
+
+// matchETag reports whether the If-Match or If-None-Match header list
+// contains etag
+func matchETag(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch validates the If-Match header of a request writing content
+func checkIfMatch(ctx context.Context, content map[string]interface{}) error {
+	c := conditionsFromContext(ctx)
+	if c == nil || c.ifMatch == "" || matchETag(c.ifMatch, contentETag(content)) {
+		return nil
+	}
+	return ErrorPreconditionFailed
+}
+
+// writeValidators sets the ETag and Last-Modified headers of response, it
+// returns true if 304 Not Modified was written instead of the response
+func writeValidators(ctx context.Context, w http.ResponseWriter, response interface{}) bool {
+	etag, modified := validators(response)
+	if etag == "" {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	c := conditionsFromContext(ctx)
+	if c == nil || c.op != OpRead || (c.method != http.MethodGet && c.method != http.MethodHead) {
+		return false
+	}
+	notModified := false
+	if c.ifNoneMatch != "" {
+		notModified = matchETag(c.ifNoneMatch, etag)
+	} else if !c.ifModifiedSince.IsZero() && !modified.IsZero() {
+		notModified = !modified.Truncate(time.Second).After(c.ifModifiedSince)
+	}
+	if notModified {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
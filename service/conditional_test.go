@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+)
+
+// conditionalContext returns the context of a request with headers
+func conditionalContext(op Operation, method string, headers map[string]string) context.Context {
+	r := httptest.NewRequest(method, "/api/en/article/a", nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return Conditions(op)(context.Background(), r)
+}
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2024, 2, 3, 10, 0, 0, 0, time.UTC)
+	resp := &api.Response{Type: "article", Language: "en", Content: map[string]interface{}{
+		"slug": "a", "updated_at": float64(modified.Unix()),
+	}}
+	etag := contentETag(resp.Content)
+
+	tests := []struct {
+		name    string
+		op      Operation
+		method  string
+		headers map[string]string
+		status  int
+	}{
+		{"no conditions", OpRead, "GET", nil, http.StatusOK},
+		{"matching etag", OpRead, "GET", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"weak etag in list", OpRead, "GET", map[string]string{"If-None-Match": `"x", W/` + etag}, http.StatusNotModified},
+		{"other etag", OpRead, "GET", map[string]string{"If-None-Match": `"x"`}, http.StatusOK},
+		{"etag takes precedence", OpRead, "GET", map[string]string{
+			"If-None-Match":     `"x"`,
+			"If-Modified-Since": modified.Format(http.TimeFormat),
+		}, http.StatusOK},
+		{"not modified since", OpRead, "GET", map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, http.StatusNotModified},
+		{"modified since", OpRead, "GET", map[string]string{"If-Modified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}, http.StatusOK},
+		{"write", OpUpdate, "PUT", map[string]string{"If-None-Match": etag}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		if err := EncodeREST(conditionalContext(tt.op, tt.method, tt.headers), w, resp); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Errorf("%s: ETag %q, want %q", tt.name, w.Header().Get("ETag"), etag)
+		}
+		if w.Header().Get("Last-Modified") != modified.Format(http.TimeFormat) {
+			t.Errorf("%s: Last-Modified %q", tt.name, w.Header().Get("Last-Modified"))
+		}
+		if tt.status == http.StatusNotModified && w.Body.Len() > 0 {
+			t.Errorf("%s: body %q", tt.name, w.Body)
+		}
+	}
+
+	// Errors have no validators
+	w := httptest.NewRecorder()
+	EncodeREST(conditionalContext(OpRead, "GET", map[string]string{"If-None-Match": "*"}), w, &api.Response{Err: api.ErrorNotFound.Error()})
+	if w.Code != http.StatusNotFound || w.Header().Get("ETag") != "" {
+		t.Errorf("error response: status %d, ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestPreconditionFailed(t *testing.T) {
+	s := newTestService(t)
+	resp, _ := s.Create(context.Background(), &api.CreateRequest{Type: "article", Language: "en", Slug: "a", Content: map[string]interface{}{"title": "a"}}, false)
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	etag := contentETag(resp.Content)
+
+	update := func(ifMatch, title string) *api.Response {
+		ctx := conditionalContext(OpUpdate, "PUT", map[string]string{"If-Match": ifMatch})
+		resp, _ := s.Update(ctx, &api.UpdateRequest{Type: "article", Language: "en", Slug: "a", Content: map[string]interface{}{"title": title}}, false)
+		return resp
+	}
+
+	resp = update(`"stale"`, "b")
+	if resp.Err != ErrorPreconditionFailed.Error() {
+		t.Fatalf("update with stale If-Match: error %q", resp.Err)
+	}
+	w := httptest.NewRecorder()
+	EncodeREST(context.Background(), w, resp)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match: status %d, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+
+	if resp = update(etag, "c"); resp.Err != "" {
+		t.Fatalf("update with current If-Match: %s", resp.Err)
+	}
+	// The tag changed with the item
+	if resp = update(etag, "d"); resp.Err != ErrorPreconditionFailed.Error() {
+		t.Errorf("update with replaced If-Match: error %q", resp.Err)
+	}
+	if resp = update("*", "e"); resp.Err != "" {
+		t.Errorf("update with If-Match *: %s", resp.Err)
+	}
+}
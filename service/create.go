@@ -6,10 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -21,10 +18,25 @@ import (
 	"golang.org/x/text/language"
 )
 
+// createOptions tunes the creation of an item
+type createOptions struct {
+	// uniqueSlug suffixes a taken slug instead of rejecting the item
+	uniqueSlug bool
+}
+
 // Create - creates a single item
 func (s *Service) Create(ctx context.Context, req *api.CreateRequest, sync bool) (*api.Response, error) {
+	// Slugs made from SlugText are suffixed until unique, an explicit
+	// slug must be free
+	return s.create(ctx, req, createOptions{uniqueSlug: req.Slug == ""})
+}
+
+// create creates a single item with opts
+func (s *Service) create(ctx context.Context, req *api.CreateRequest, opts createOptions) (*api.Response, error) {
+	s.langMu.RLock()
+	defer s.langMu.RUnlock()
+
 	var resp = api.Response{Type: req.Type, Language: req.Language}
-	var db *bolt.DB
 	var err error
 
 	// Set the language code
@@ -32,20 +44,14 @@ func (s *Service) Create(ctx context.Context, req *api.CreateRequest, sync bool)
 		req.Language = language.English.String()
 	}
 	// Validate the content type
-	if _, ok := Index[req.Type]; !ok {
+	if !s.hasType(req.Type) {
 		resp.Err = api.ErrorInvalidContentType.Error()
 		return &resp, nil
 	}
 
-	// Open database in read-write mode
-	db, err = bolt.Open(DBFile, 0644, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		bb, err := getBucket(tx, req.Type, req.Language)
+	var changes fileChanges
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bb, err := s.bucket(tx, req.Type, req.Language)
 		if err != nil {
 			return err
 		}
@@ -64,18 +70,56 @@ func (s *Service) Create(ctx context.Context, req *api.CreateRequest, sync bool)
 		item["id"] = nextSeq
 
 		if req.Slug != "" {
-			item["slug"] = req.Slug
+			if item["slug"], err = normalizeSlug(req.Slug, req.Language); err != nil {
+				return err
+			}
 		} else if req.SlugText != "" {
-			item["slug"] = stringToSlug(req.SlugText)
+			item["slug"] = stringToSlug(req.SlugText, req.Language)
 		} else {
 			return errors.New("Empty Key")
 		}
 
+		slug := item["slug"].(string)
+		newSlug := slug
+		if opts.uniqueSlug {
+			// Find a unique slug
+			for i := 2; bb.Get([]byte(newSlug)) != nil; i++ {
+				newSlug = fmt.Sprintf("%s-%d", slug, i)
+			}
+			item["slug"] = newSlug
+		} else if bb.Get([]byte(newSlug)) != nil {
+			return ErrorSlugExists
+		}
+
 		item["created_at"] = time.Now().Unix()
 		item["updated_at"] = time.Now().Unix()
-		item["deleted_at"] = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+		item["deleted_at"] = notDeleted
+		item["version"] = 1
+
+		// Translations share the group of their source item, a group has
+		// a single item per language
+		if group, _ := item["translation_group"].(string); group == "" {
+			item["translation_group"] = fmt.Sprintf("%s-%d", req.Language, nextSeq)
+		} else if members, err := readGroup(tx, req.Type, group); err != nil {
+			return err
+		} else if _, ok := members[req.Language]; ok {
+			return ErrorTranslationExists
+		}
+
+		if err := s.validate(req.Type, item); err != nil {
+			return err
+		}
+
+		// New items are drafts unless status is provided
+		if _, ok := item["status"]; !ok {
+			item["status"] = StatusDraft
+		}
+		if err := checkWorkflow(nil, item); err != nil {
+			return err
+		}
 
-		// Copy file(s)
+		// Copy file(s) once the item is valid, they are removed again if
+		// it isn't committed
 		for k, v := range item {
 			if strings.HasPrefix(k, "file:") {
 				var file i.File
@@ -87,54 +131,30 @@ func (s *Service) Create(ctx context.Context, req *api.CreateRequest, sync bool)
 				}
 
 				if file.URI != "" {
-					// Use the existing file URI
-				} else if file.Name != "" && file.Size > 0 && len(file.Bytes) > 0 {
-					file.URI = fmt.Sprintf("/drive/%s/%s/%d/%s", req.Type, req.Language, nextSeq, file.Name)
-					filemap := v.(map[string]interface{})
-					filemap["uri"] = file.URI
-					filemap["bytes"] = nil
-
-					// Create path
-					path := fmt.Sprintf("drive/%s/%s/%d", req.Type, req.Language, nextSeq)
-					if err := os.MkdirAll(path, os.ModeDir|os.ModePerm); err != nil {
+					// Use the stored file, staged uploads are moved into
+					// the item directory
+					if err := s.adoptUpload(ctx, v.(map[string]interface{}), req.Type, req.Language, int64(nextSeq), s.fileRule(req.Type, k), &changes); err != nil {
 						return err
 					}
-
-					// Create file
-					dst, err := os.Create(path + "/" + file.Name)
+				} else if file.Name != "" && file.Size > 0 && len(file.Bytes) > 0 {
+					name, err := fileName(file.Name)
 					if err != nil {
 						return err
 					}
-					defer dst.Close()
 
-					// Copy the uploaded file to the destination file
-					buff := bytes.NewBuffer(file.Bytes)
-					if _, err := io.Copy(dst, buff); err != nil {
+					// Store the uploaded file
+					buff := bytes.NewReader(file.Bytes)
+					stored, err := s.writeFile(ctx, itemDir(req.Type, req.Language, int64(nextSeq)), name, buff, int64(len(file.Bytes)), s.fileRule(req.Type, k))
+					if err != nil {
 						return err
 					}
+					key, _ := fileKey(stored.URI)
+					changes.written = append(changes.written, key)
+					setFile(v.(map[string]interface{}), stored)
 				}
 			}
 		}
 
-		// Assign empty status if not provided
-		if _, ok := item["status"]; !ok {
-			item["status"] = ""
-		}
-
-		// Find new slug only if SlugText is provided
-		slug := item["slug"].(string)
-		newSlug := slug
-		if req.Slug == "" && req.SlugText != "" {
-			var i int
-			// Find a unique slug
-			for i = 2; bb.Get([]byte(newSlug)) != nil; i++ {
-				newSlug = fmt.Sprintf("%s-%d", slug, i)
-			}
-			if i > 2 {
-				item["slug"] = newSlug
-			}
-		}
-
 		j, err := json.Marshal(item)
 		if err != nil {
 			return err
@@ -143,12 +163,20 @@ func (s *Service) Create(ctx context.Context, req *api.CreateRequest, sync bool)
 		if err != nil {
 			return err
 		}
+		_, err = s.addRevision(ctx, tx, req.Type, req.Language, "create", item)
+		if err != nil {
+			return err
+		}
+		err = putGroupMember(tx, req.Type, req.Language, item)
+		if err != nil {
+			return err
+		}
 
 		resp.Content = item
 
 		// Create index
 		var index bleve.Index
-		index, err = getIndex(req.Type, req.Language)
+		index, err = s.getIndex(req.Type, req.Language)
 		if err != nil {
 			return err
 		}
@@ -156,19 +184,27 @@ func (s *Service) Create(ctx context.Context, req *api.CreateRequest, sync bool)
 		if err != nil {
 			return err
 		}
+		err = syncRevision(tx, index, req.Type, req.Language)
+		if err != nil {
+			return err
+		}
 
 		return nil
 	})
 	if err != nil {
-		resp.Err = err.Error()
+		changes.undo(ctx, s.Storage)
+		resp.Err, resp.Content = validationResponse(err)
 		return &resp, nil
 	}
 
+	// Drop the cached responses
+	s.cache.invalidate(req.Type, req.Language)
+
 	return &resp, nil
 }
 
 // CreateEndpoint - creates endpoint for Create service
-func CreateEndpoint(svc Service) endpoint.Endpoint {
+func CreateEndpoint(svc *Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(api.CreateRequest)
 		return svc.Create(ctx, &req, false)
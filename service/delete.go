@@ -3,59 +3,76 @@ package service
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
+	"time"
 
 	"git.urantiatech.com/cloudcms/cloudcms/api"
 	"github.com/boltdb/bolt"
 	"github.com/go-kit/kit/endpoint"
 )
 
-// Delete - creates a single item
+// Delete - moves a single item to trash, it can be restored until purged
 func (s *Service) Delete(ctx context.Context, req *api.DeleteRequest, sync bool) (*api.Response, error) {
+	s.langMu.RLock()
+	defer s.langMu.RUnlock()
+
 	var resp = api.Response{Type: req.Type, Language: req.Language}
-	var db *bolt.DB
 	var err error
 
-	if _, ok := Index[req.Type]; !ok {
+	if !s.hasType(req.Type) {
 		resp.Err = api.ErrorInvalidContentType.Error()
 		return &resp, nil
 	}
 
-	// Open database in read-write mode
-	// It will be created if it doesn't exist.
-	//options := bolt.Options{ReadOnly: false}
-
-	db, err = bolt.Open(DBFile, 0644, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		bb, err := getBucket(tx, req.Type, req.Language)
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bb, err := s.bucket(tx, req.Type, req.Language)
 		if err != nil {
 			return err
 		}
 
 		// Get the existing value
+		var content map[string]interface{}
 		val := bb.Get([]byte(req.Slug))
 		if val == nil {
 			return api.ErrorNotFound
 		}
-
-		err = json.Unmarshal(val, &resp.Content)
+		err = json.Unmarshal(val, &content)
 		if err != nil {
 			return err
 		}
+		if isTrashed(content) {
+			return api.ErrorNotFound
+		}
+		if err := checkVersion(ctx, content, nil); err != nil {
+			resp.Content = content
+			return err
+		}
 
-		err = bb.Delete([]byte(req.Slug))
+		// Keep the item in database, only mark it as deleted
+		content["deleted_at"] = time.Now().Unix()
+		nextVersion(content, itemVersion(content))
+		j, err := json.Marshal(content)
+		if err != nil {
+			return err
+		}
+		err = bb.Put([]byte(req.Slug), j)
+		if err != nil {
+			return err
+		}
+		_, err = s.addRevision(ctx, tx, req.Type, req.Language, "delete", content)
+		if err != nil {
+			return err
+		}
+		err = putGroupMember(tx, req.Type, req.Language, content)
 		if err != nil {
 			return err
 		}
 
-		index, err := getIndex(req.Type, req.Language)
+		resp.Content = content
+
+		// Trashed items are not searchable
+
+		index, err := s.getIndex(req.Type, req.Language)
 		if err != nil {
 			return err
 		}
@@ -63,6 +80,10 @@ func (s *Service) Delete(ctx context.Context, req *api.DeleteRequest, sync bool)
 		if err != nil {
 			return err
 		}
+		err = syncRevision(tx, index, req.Type, req.Language)
+		if err != nil {
+			return err
+		}
 
 		return nil
 	})
@@ -71,18 +92,14 @@ func (s *Service) Delete(ctx context.Context, req *api.DeleteRequest, sync bool)
 		return &resp, nil
 	}
 
-	// Delete from the cache
-	key := fmt.Sprintf("%s.%s.%s", req.Language, req.Type, req.Slug)
-	RespCache.Delete(key)
-
-	fields := resp.Content.(map[string]interface{})
-	fields["status"] = "deleted"
+	// Drop the cached responses
+	s.cache.invalidate(req.Type, req.Language)
 
 	return &resp, nil
 }
 
 // DeleteEndpoint - creates endpoint for Delete service
-func DeleteEndpoint(svc Service) endpoint.Endpoint {
+func DeleteEndpoint(svc *Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(api.DeleteRequest)
 		return svc.Delete(ctx, &req, false)
@@ -3,7 +3,6 @@ package service
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"time"
 
@@ -16,20 +15,24 @@ import (
 
 // FacetsSearch - searches for query with multiple facets
 func (s *Service) FacetsSearch(ctx context.Context, req *api.FacetsSearchRequest) (*api.FacetsSearchResults, error) {
-
-	if j, err := json.Marshal(req); err == nil {
-		fmt.Println(string(j))
-	}
+	s.langMu.RLock()
+	defer s.langMu.RUnlock()
 
 	var resp = api.FacetsSearchResults{Type: req.Type}
 	var searchRequest *bleve.SearchRequest
 	var query q.Query
 
-	if _, ok := Index[req.Type]; !ok {
+	if !s.hasType(req.Type) {
 		resp.Err = api.ErrorInvalidContentType.Error()
 		return &resp, nil
 	}
 
+	key := s.cache.key(ctx, "facets", req.Type, req, req.Language)
+	if r, ok := s.cache.get(key); ok {
+		cached := *r.(*api.FacetsSearchResults)
+		return &cached, nil
+	}
+
 	if req.Query == "" {
 		query = bleve.NewMatchAllQuery()
 	} else if req.Fuzzy {
@@ -38,8 +41,8 @@ func (s *Service) FacetsSearch(ctx context.Context, req *api.FacetsSearchRequest
 		query = bleve.NewQueryStringQuery(req.Query)
 	}
 
-	// Create a new search request
-	searchRequest = bleve.NewSearchRequest(query)
+	// Create a new search request, public requests only see published items
+	searchRequest = bleve.NewSearchRequest(publicQuery(ctx, query))
 	if req.Query == "" {
 		sf := &search.SortField{Field: "created_at", Desc: true}
 		searchRequest.SortByCustom(search.SortOrder{sf})
@@ -71,7 +74,7 @@ func (s *Service) FacetsSearch(ctx context.Context, req *api.FacetsSearchRequest
 	}
 	searchRequest.From = req.Skip
 
-	index, err := getIndex(req.Type, req.Language)
+	index, err := s.getIndex(req.Type, req.Language)
 	if err != nil {
 		resp.Err = api.ErrorNotFound.Error()
 		return &resp, nil
@@ -139,11 +142,14 @@ func (s *Service) FacetsSearch(ctx context.Context, req *api.FacetsSearchRequest
 		resp.Facets[fname] = &facetResult
 	}
 
+	cached := resp
+	s.cache.set(key, &cached)
+
 	return &resp, nil
 }
 
 // FacetsSearchEndpoint - creates endpoint for Search service
-func FacetsSearchEndpoint(svc Service) endpoint.Endpoint {
+func FacetsSearchEndpoint(svc *Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(api.FacetsSearchRequest)
 		return svc.FacetsSearch(ctx, &req)
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/boltdb/bolt"
+	"golang.org/x/text/language"
+)
+
+// ParseFallbacks parses fallback chains of languages, e.g. "pt-BR:pt,en;hi:en"
+func ParseFallbacks(spec string) map[string][]string {
+	fallbacks := make(map[string][]string)
+	for _, chain := range strings.Split(spec, ";") {
+		parts := strings.SplitN(chain, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			continue
+		}
+		from := strings.TrimSpace(parts[0])
+		for _, l := range strings.Split(parts[1], ",") {
+			if l = strings.TrimSpace(l); l != "" {
+				fallbacks[from] = append(fallbacks[from], l)
+			}
+		}
+	}
+	return fallbacks
+}
+
+// isEnabled reports whether content is stored in language
+func (s *Service) isEnabled(lang string) bool {
+	for _, l := range s.languages() {
+		if l.String() == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackChain returns lang followed by the enabled languages served when
+// an item is missing in lang. Languages without a configured chain fall
+// back to their BCP 47 parents, then to the first language.
+func (s *Service) fallbackChain(lang string) []string {
+	chain := []string{lang}
+	if !s.Fallback {
+		return chain
+	}
+
+	candidates, ok := s.Fallbacks[lang]
+	if !ok {
+		if tag, err := language.Parse(lang); err == nil {
+			for p := tag.Parent(); p != language.Und; p = p.Parent() {
+				candidates = append(candidates, p.String())
+			}
+		}
+		if languages := s.languages(); len(languages) > 0 {
+			candidates = append(candidates, languages[0].String())
+		}
+	}
+
+	seen := map[string]bool{lang: true}
+	for _, l := range candidates {
+		if !seen[l] && s.isEnabled(l) {
+			chain = append(chain, l)
+			seen[l] = true
+		}
+	}
+	return chain
+}
+
+// isVisible reports whether an item can be served to the request
+func isVisible(ctx context.Context, content map[string]interface{}) bool {
+	if isTrashed(content) {
+		return false
+	}
+	status, _ := content["status"].(string)
+	return status == StatusPublished || !isPublic(ctx)
+}
+
+// hiddenByFallback returns the slugs of each fallback language whose item
+// is served in an earlier language of chain
+func (s *Service) hiddenByFallback(ctx context.Context, contentType string, chain []string) ([][]string, error) {
+	hidden := make([][]string, len(chain))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := typeGroups(tx, contentType)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			members, err := decodeGroup(v)
+			if err != nil {
+				return err
+			}
+			served := false
+			for i, l := range chain {
+				m, ok := members[l]
+				if !ok {
+					continue
+				}
+				if served {
+					hidden[i] = append(hidden[i], m.Slug)
+				} else if isVisibleMember(ctx, m) {
+					served = true
+				}
+			}
+			return nil
+		})
+	})
+	return hidden, err
+}
+
+// searchChain runs searchRequest on the indexes of the languages of chain
+// and merges the results. Items translated to an earlier language of the
+// chain are skipped.
+func (s *Service) searchChain(ctx context.Context, contentType string, chain []string, searchRequest *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	if len(chain) == 1 {
+		index, err := s.getIndex(contentType, chain[0])
+		if err != nil {
+			return nil, err
+		}
+		return index.Search(searchRequest)
+	}
+
+	hidden, err := s.hiddenByFallback(ctx, contentType, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every index returns the hits up to the requested page
+	size := searchRequest.From + searchRequest.Size
+	if searchRequest.Size > math.MaxInt32-searchRequest.From {
+		size = math.MaxInt32
+	}
+
+	var result = bleve.SearchResult{Request: searchRequest, Status: &bleve.SearchStatus{}}
+	for i, l := range chain {
+		index, err := s.getIndex(contentType, l)
+		if err != nil {
+			return nil, err
+		}
+
+		r := *searchRequest
+		r.From, r.Size = 0, size
+		r.Sort = searchRequest.Sort.Copy()
+		if len(hidden[i]) > 0 {
+			query := bleve.NewBooleanQuery()
+			query.AddMust(searchRequest.Query)
+			query.AddMustNot(bleve.NewDocIDQuery(hidden[i]))
+			r.Query = query
+		}
+		res, err := index.Search(&r)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Status.Merge(res.Status)
+		result.Hits = append(result.Hits, res.Hits...)
+		result.Total += res.Total
+		result.Took += res.Took
+		if res.MaxScore > result.MaxScore {
+			result.MaxScore = res.MaxScore
+		}
+	}
+
+	order := searchRequest.Sort
+	scoring, desc := order.CacheIsScore(), order.CacheDescending()
+	sort.SliceStable(result.Hits, func(i, j int) bool {
+		return order.Compare(scoring, desc, result.Hits[i], result.Hits[j]) < 0
+	})
+
+	if searchRequest.From >= len(result.Hits) {
+		result.Hits = nil
+	} else {
+		result.Hits = result.Hits[searchRequest.From:]
+	}
+	if len(result.Hits) > searchRequest.Size {
+		result.Hits = result.Hits[:searchRequest.Size]
+	}
+	return &result, nil
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestParseFallbacks(t *testing.T) {
+	tests := []struct {
+		spec      string
+		fallbacks map[string][]string
+	}{
+		{"", map[string][]string{}},
+		{"hi:en", map[string][]string{"hi": {"en"}}},
+		{"pt-BR:pt,en;hi:en", map[string][]string{"pt-BR": {"pt", "en"}, "hi": {"en"}}},
+		{" hi : en , , fr ;", map[string][]string{"hi": {"en", "fr"}}},
+		{"hi:en;hi:fr", map[string][]string{"hi": {"en", "fr"}}},
+		{"bogus;:en;fr:", map[string][]string{}},
+	}
+	for _, tt := range tests {
+		if fallbacks := ParseFallbacks(tt.spec); !reflect.DeepEqual(fallbacks, tt.fallbacks) {
+			t.Errorf("ParseFallbacks(%q) = %v, want %v", tt.spec, fallbacks, tt.fallbacks)
+		}
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	languages := []language.Tag{language.English, language.Hindi, language.Portuguese, language.BrazilianPortuguese, language.French}
+	tests := []struct {
+		name      string
+		fallback  bool
+		fallbacks map[string][]string
+		lang      string
+		chain     []string
+	}{
+		{"disabled", false, nil, "hi", []string{"hi"}},
+		{"first language", true, nil, "en", []string{"en"}},
+		{"default chain", true, nil, "hi", []string{"hi", "en"}},
+		{"parent language", true, nil, "pt-BR", []string{"pt-BR", "pt", "en"}},
+		{"parents not enabled", true, nil, "en-GB", []string{"en-GB", "en"}},
+		{"configured chain", true, map[string][]string{"pt-BR": {"fr", "en"}}, "pt-BR", []string{"pt-BR", "fr", "en"}},
+		{"configured chain replaces parents", true, map[string][]string{"pt-BR": {"fr"}}, "pt-BR", []string{"pt-BR", "fr"}},
+		{"disabled and repeated languages", true, map[string][]string{"hi": {"de", "en", "hi", "en"}}, "hi", []string{"hi", "en"}},
+		{"empty chain", true, map[string][]string{"fr": nil}, "fr", []string{"fr"}},
+		{"chain of other language", true, map[string][]string{"hi": {"fr"}}, "pt", []string{"pt", "en"}},
+	}
+	for _, tt := range tests {
+		s := &Service{Languages: languages, Fallback: tt.fallback, Fallbacks: tt.fallbacks}
+		if chain := s.fallbackChain(tt.lang); !reflect.DeepEqual(chain, tt.chain) {
+			t.Errorf("%s: fallbackChain(%q) = %v, want %v", tt.name, tt.lang, chain, tt.chain)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/endpoint"
+)
+
+// defaultMinAge protects files uploaded but not yet referenced by an item
+const defaultMinAge = 24 * 60 * 60
+
+// GCRequest selects the files collected by CollectGarbage
+type GCRequest struct {
+	// DryRun only reports the unreferenced files
+	DryRun bool `json:"dry_run"`
+
+	// MinAge in seconds of the files and upload sessions collected, one day
+	// if zero and no limit if negative
+	MinAge int64 `json:"min_age"`
+}
+
+// GCResponse reports the unreferenced files and expired upload sessions
+type GCResponse struct {
+	DryRun   bool       `json:"dry_run"`
+	Files    []FileInfo `json:"files"`
+	Size     int64      `json:"size"`
+	Sessions []string   `json:"sessions"`
+	Err      string     `json:"error,omitempty"`
+}
+
+// fileRefs returns the storage keys of the files referenced by content
+func (s *Service) fileRefs(contentType string, content map[string]interface{}) map[string]bool {
+	names := make(map[string]bool)
+	for k := range content {
+		if strings.HasPrefix(k, "file:") {
+			names[k] = true
+		}
+	}
+	for _, f := range s.fieldsOf(contentType) {
+		if f.Type == "file" {
+			names[f.Name] = true
+		}
+	}
+
+	refs := make(map[string]bool)
+	for name := range names {
+		filemap, ok := content[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		uri, _ := filemap["uri"].(string)
+		if key, ok := fileKey(uri); ok {
+			refs[key] = true
+		}
+	}
+	return refs
+}
+
+// revisionRefs adds the storage keys of the files referenced by the
+// revisions in b to refs
+func (s *Service) revisionRefs(contentType string, b *bolt.Bucket, refs map[string]bool) error {
+	return b.ForEach(func(k, v []byte) error {
+		var rev Revision
+		if err := json.Unmarshal(v, &rev); err != nil {
+			return err
+		}
+		for key := range s.fileRefs(contentType, rev.Content) {
+			refs[key] = true
+		}
+		return nil
+	})
+}
+
+// deleteReplaced removes the files of the item directory referenced by
+// before or by the pruned revisions but neither by after nor by a revision
+// kept in the history of the item. Files of other items are never removed.
+func (s *Service) deleteReplaced(ctx context.Context, contentType, language string, before, after map[string]interface{}, pruned map[string]bool) error {
+	id, ok := toInt64(before["id"])
+	if !ok {
+		return nil
+	}
+	candidates := s.fileRefs(contentType, before)
+	for key := range pruned {
+		candidates[key] = true
+	}
+	refs := s.fileRefs(contentType, after)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := historyBucket(tx, contentType, language, id, false)
+		if err != nil || b == nil {
+			return err
+		}
+		return s.revisionRefs(contentType, b, refs)
+	})
+	if err != nil {
+		return err
+	}
+
+	dir := itemDir(contentType, language, id) + "/"
+	for key := range candidates {
+		if refs[key] || !strings.HasPrefix(key, dir) {
+			continue
+		}
+		if err := s.Storage.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CollectGarbage - removes the stored files no longer referenced by any
+// item or revision and the expired resumable upload sessions. Files of
+// trashed items are kept until the item is purged.
+func (s *Service) CollectGarbage(ctx context.Context, req *GCRequest) (*GCResponse, error) {
+	var resp = GCResponse{DryRun: req.DryRun, Files: []FileInfo{}, Sessions: []string{}}
+
+	minAge := req.MinAge
+	if minAge == 0 {
+		minAge = defaultMinAge
+	}
+	before := time.Now().Add(-time.Duration(minAge) * time.Second)
+
+	// Collect the references of all items
+	refs := make(map[string]bool)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Disabled languages keep their files
+		for t := range s.indexes() {
+			b := tx.Bucket([]byte(t))
+			if b == nil {
+				continue
+			}
+			err := b.ForEach(func(l, v []byte) error {
+				bb := b.Bucket(l)
+				if v != nil || bb == nil {
+					return nil
+				}
+				return bb.ForEach(func(k, v []byte) error {
+					var content map[string]interface{}
+					if err := json.Unmarshal(v, &content); err != nil {
+						return err
+					}
+					for key := range s.fileRefs(t, content) {
+						refs[key] = true
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// Revisions keep their files for rollbacks
+		h := tx.Bucket([]byte(HistoryBucket))
+		if h == nil {
+			return nil
+		}
+		for t := range s.indexes() {
+			b := h.Bucket([]byte(t))
+			if b == nil {
+				continue
+			}
+			err := b.ForEach(func(l, v []byte) error {
+				bl := b.Bucket(l)
+				if v != nil || bl == nil {
+					return nil
+				}
+				return bl.ForEach(func(id, v []byte) error {
+					if bi := bl.Bucket(id); v == nil && bi != nil {
+						return s.revisionRefs(t, bi, refs)
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+
+	// Only files below the directories of known content types are ours
+	for t := range s.indexes() {
+		list, err := s.Storage.List(ctx, t+"/")
+		if err != nil {
+			resp.Err = err.Error()
+			return &resp, nil
+		}
+		for _, f := range list {
+			if refs[f.Key] || (minAge > 0 && f.ModTime.After(before)) {
+				continue
+			}
+			if !req.DryRun {
+				if err := s.Storage.Delete(ctx, f.Key); err != nil {
+					resp.Err = err.Error()
+					return &resp, nil
+				}
+			}
+			resp.Files = append(resp.Files, f)
+			resp.Size += f.Size
+		}
+	}
+
+	err = s.expireSessions(req.DryRun, minAge, before, &resp)
+	if err != nil {
+		resp.Err = err.Error()
+	}
+	return &resp, nil
+}
+
+// expireSessions removes the resumable uploads started before
+func (s *Service) expireSessions(dryRun bool, minAge int64, before time.Time, resp *GCResponse) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(UploadBucket))
+		if b == nil {
+			return nil
+		}
+		var expired []string
+		err := b.ForEach(func(k, v []byte) error {
+			var session UploadSession
+			if err := json.Unmarshal(v, &session); err != nil {
+				return err
+			}
+			if minAge < 0 || session.CreatedAt < before.Unix() {
+				expired = append(expired, session.Token)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		resp.Sessions = append(resp.Sessions, expired...)
+		if dryRun {
+			return nil
+		}
+		for _, token := range expired {
+			if err := b.Delete([]byte(token)); err != nil {
+				return err
+			}
+			if err := os.Remove(s.chunkPath(token)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GCEndpoint - creates endpoint for CollectGarbage service
+func GCEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GCRequest)
+		return svc.CollectGarbage(ctx, &req)
+	}
+}
+
+// DecodeGCReq - decodes the incoming request, an empty body collects with
+// the default options
+func DecodeGCReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request GCRequest
+	if r.ContentLength == 0 {
+		return request, nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
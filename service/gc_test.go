@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"sort"
+	"testing"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+)
+
+// fileContent returns the content of an item with file field cover
+func fileContent(name, data string) map[string]interface{} {
+	return map[string]interface{}{
+		"file:cover": map[string]interface{}{
+			"name":  name,
+			"size":  len(data),
+			"bytes": base64.StdEncoding.EncodeToString([]byte(data)),
+		},
+	}
+}
+
+func TestDeleteReplacedFiles(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxRevisions int
+		files        []string
+		kept         []string
+		removed      []string
+	}{
+		{"revision keeps replaced file", 0, []string{"a.txt", "b.txt"}, []string{"a.txt", "b.txt"}, nil},
+		{"pruned revision frees replaced file", 1, []string{"a.txt", "b.txt"}, []string{"b.txt"}, []string{"a.txt"}},
+		{"only pruned revisions free files", 2, []string{"a.txt", "b.txt", "c.txt"}, []string{"b.txt", "c.txt"}, []string{"a.txt"}},
+		{"history kept forever", -1, []string{"a.txt", "b.txt", "c.txt"}, []string{"a.txt", "b.txt", "c.txt"}, nil},
+	}
+	for _, tt := range tests {
+		s := newTestService(t)
+		s.MaxRevisions = tt.maxRevisions
+		ctx := context.Background()
+
+		resp, _ := s.Create(ctx, &api.CreateRequest{Type: "article", Language: "en", Slug: "a", Content: fileContent(tt.files[0], "hi")}, false)
+		if resp.Err != "" {
+			t.Fatalf("%s: %s", tt.name, resp.Err)
+		}
+		for _, name := range tt.files[1:] {
+			resp, _ := s.Update(ctx, &api.UpdateRequest{Type: "article", Language: "en", Slug: "a", Content: fileContent(name, "ho")}, false)
+			if resp.Err != "" {
+				t.Fatalf("%s: %s", tt.name, resp.Err)
+			}
+		}
+
+		for _, name := range tt.kept {
+			if _, err := s.Storage.Stat(ctx, "article/en/1/"+name); err != nil {
+				t.Errorf("%s: %s: %v", tt.name, name, err)
+			}
+		}
+		for _, name := range tt.removed {
+			if _, err := s.Storage.Stat(ctx, "article/en/1/"+name); err != ErrorFileNotFound {
+				t.Errorf("%s: %s not removed: %v", tt.name, name, err)
+			}
+		}
+	}
+}
+
+func TestPruneRevisions(t *testing.T) {
+	s := newTestService(t)
+	s.MaxRevisions = 3
+	ctx := context.Background()
+
+	s.Create(ctx, &api.CreateRequest{Type: "article", Language: "en", Slug: "a", Content: map[string]interface{}{"title": "0"}}, false)
+	for _, title := range []string{"1", "2", "3", "4"} {
+		s.Update(ctx, &api.UpdateRequest{Type: "article", Language: "en", Slug: "a", Content: map[string]interface{}{"title": title}}, false)
+	}
+
+	editor := WithUser(ctx, &User{Name: "e", Role: RoleEditor})
+	resp, _ := s.Revisions(editor, &RevisionRequest{Type: "article", Language: "en", Slug: "a"})
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	var revisions []uint64
+	for _, rev := range resp.Revisions {
+		revisions = append(revisions, rev.Revision)
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i] < revisions[j] })
+	if !reflect.DeepEqual(revisions, []uint64{3, 4, 5}) {
+		t.Errorf("revisions %v, want [3 4 5]", revisions)
+	}
+}
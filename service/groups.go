@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+)
+
+// GroupBucket holds the members of the translation groups, nested by
+// content type and keyed by group
+const GroupBucket = "_groups"
+
+// groupsKey is the MetaBucket key set once GroupBucket is built
+var groupsKey = []byte("groups")
+
+// groupMember is the item of a translation group in a language
+type groupMember struct {
+	Slug   string `json:"slug"`
+	Status string `json:"status"`
+}
+
+// typeGroups returns the translation groups of content type, nil if none
+// were stored yet
+func typeGroups(tx *bolt.Tx, contentType string) *bolt.Bucket {
+	b := tx.Bucket([]byte(GroupBucket))
+	if b == nil {
+		return nil
+	}
+	return b.Bucket([]byte(contentType))
+}
+
+// decodeGroup returns the members of a group by language
+func decodeGroup(v []byte) (map[string]groupMember, error) {
+	members := make(map[string]groupMember)
+	if v == nil {
+		return members, nil
+	}
+	if err := json.Unmarshal(v, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// readGroup returns the members of group by language
+func readGroup(tx *bolt.Tx, contentType, group string) (map[string]groupMember, error) {
+	b := typeGroups(tx, contentType)
+	if b == nil {
+		return make(map[string]groupMember), nil
+	}
+	return decodeGroup(b.Get([]byte(group)))
+}
+
+// writeGroup stores the members of group, empty groups are removed
+func writeGroup(tx *bolt.Tx, contentType, group string, members map[string]groupMember) error {
+	gb, err := tx.CreateBucketIfNotExists([]byte(GroupBucket))
+	if err != nil {
+		return err
+	}
+	b, err := gb.CreateBucketIfNotExists([]byte(contentType))
+	if err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return b.Delete([]byte(group))
+	}
+	j, err := json.Marshal(members)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(group), j)
+}
+
+// putGroupMember records content as the member of its translation group in
+// language. It must be called by every write of an item, trashed items
+// leave their group.
+func putGroupMember(tx *bolt.Tx, contentType, language string, content map[string]interface{}) error {
+	if isTrashed(content) {
+		return deleteGroupMember(tx, contentType, language, content)
+	}
+	group := translationGroup(content)
+	members, err := readGroup(tx, contentType, group)
+	if err != nil {
+		return err
+	}
+	slug, _ := content["slug"].(string)
+	status, _ := content["status"].(string)
+	members[language] = groupMember{Slug: slug, Status: status}
+	return writeGroup(tx, contentType, group, members)
+}
+
+// deleteGroupMember removes content from its translation group
+func deleteGroupMember(tx *bolt.Tx, contentType, language string, content map[string]interface{}) error {
+	group := translationGroup(content)
+	members, err := readGroup(tx, contentType, group)
+	if err != nil {
+		return err
+	}
+	slug, _ := content["slug"].(string)
+	if m, ok := members[language]; !ok || m.Slug != slug {
+		return nil
+	}
+	delete(members, language)
+	return writeGroup(tx, contentType, group, members)
+}
+
+// buildGroups records the translation groups of the items of content types
+// stored before GroupBucket existed, in all languages including disabled
+// ones
+func buildGroups(tx *bolt.Tx, types []TypeDefinition) error {
+	for _, def := range types {
+		b := tx.Bucket([]byte(def.Name))
+		if b == nil {
+			continue
+		}
+		err := b.ForEach(func(l, v []byte) error {
+			bb := b.Bucket(l)
+			if v != nil || bb == nil {
+				return nil
+			}
+			return bb.ForEach(func(k, v []byte) error {
+				var content map[string]interface{}
+				if err := json.Unmarshal(v, &content); err != nil {
+					return err
+				}
+				return putGroupMember(tx, def.Name, string(l), content)
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isVisibleMember reports whether the member of a group can be served to
+// the request
+func isVisibleMember(ctx context.Context, m groupMember) bool {
+	return m.Status == StatusPublished || !isPublic(ctx)
+}
@@ -0,0 +1,486 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/endpoint"
+)
+
+// HistoryBucket holds the revisions of all items, nested by content type,
+// language and item id
+const HistoryBucket = "_history"
+
+// ErrorRevisionNotFound is returned for unknown revisions
+var ErrorRevisionNotFound = errors.New("Revision not found")
+
+// Revision is an immutable snapshot of an item
+type Revision struct {
+	Revision  uint64                 `json:"revision"`
+	Action    string                 `json:"action"`
+	Author    string                 `json:"author,omitempty"`
+	Timestamp int64                  `json:"timestamp"`
+	Content   map[string]interface{} `json:"content,omitempty"`
+}
+
+// Change of a single field between two revisions
+type Change struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// RevisionRequest selects the revisions of an item, Revision and To are
+// used by the single revision, rollback and diff requests
+type RevisionRequest struct {
+	Type     string `json:"type"`
+	Language string `json:"language"`
+	Slug     string `json:"slug"`
+	Revision uint64 `json:"revision"`
+	To       uint64 `json:"to"`
+}
+
+// RevisionsResponse lists the revisions of an item without their content
+type RevisionsResponse struct {
+	Type      string     `json:"type"`
+	Language  string     `json:"language"`
+	Slug      string     `json:"slug"`
+	Revisions []Revision `json:"revisions"`
+	Err       string     `json:"error,omitempty"`
+}
+
+// RevisionResponse returns a single revision
+type RevisionResponse struct {
+	Type     string    `json:"type"`
+	Language string    `json:"language"`
+	Slug     string    `json:"slug"`
+	Revision *Revision `json:"revision"`
+	Err      string    `json:"error,omitempty"`
+}
+
+// DiffResponse lists the fields changed between two revisions
+type DiffResponse struct {
+	Type     string   `json:"type"`
+	Language string   `json:"language"`
+	Slug     string   `json:"slug"`
+	From     uint64   `json:"from"`
+	To       uint64   `json:"to"`
+	Changes  []Change `json:"changes"`
+	Err      string   `json:"error,omitempty"`
+}
+
+// historyBucket returns the revisions bucket of an item, it is created if
+// create is set and nil is returned if it doesn't exist otherwise
+func historyBucket(tx *bolt.Tx, contentType, language string, id int64, create bool) (*bolt.Bucket, error) {
+	names := []string{HistoryBucket, contentType, language, strconv.FormatInt(id, 10)}
+	if !create {
+		b := tx.Bucket([]byte(names[0]))
+		for _, name := range names[1:] {
+			if b == nil {
+				return nil, nil
+			}
+			b = b.Bucket([]byte(name))
+		}
+		return b, nil
+	}
+
+	b, err := tx.CreateBucketIfNotExists([]byte(names[0]))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names[1:] {
+		if b, err = b.CreateBucketIfNotExists([]byte(name)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// DefaultMaxRevisions is the number of revisions kept per item if
+// MaxRevisions is zero
+const DefaultMaxRevisions = 50
+
+// addRevision appends a snapshot of content to the history of the item and
+// prunes the oldest revisions beyond MaxRevisions. It returns the storage
+// keys of the files referenced by the pruned revisions.
+func (s *Service) addRevision(ctx context.Context, tx *bolt.Tx, contentType, language, action string, content map[string]interface{}) (map[string]bool, error) {
+	id, ok := toInt64(content["id"])
+	if !ok {
+		return nil, errors.New("Invalid item id")
+	}
+	b, err := historyBucket(tx, contentType, language, id, true)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+
+	rev := Revision{
+		Revision:  seq,
+		Action:    action,
+		Timestamp: time.Now().Unix(),
+		Content:   content,
+	}
+	if user := UserFromContext(ctx); user != nil {
+		rev.Author = user.Name
+	}
+	j, err := json.Marshal(rev)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Put(revisionKeyOf(seq), j); err != nil {
+		return nil, err
+	}
+	return s.pruneRevisions(contentType, b)
+}
+
+// pruneRevisions deletes the oldest revisions in b beyond MaxRevisions and
+// returns the storage keys of the files they reference
+func (s *Service) pruneRevisions(contentType string, b *bolt.Bucket) (map[string]bool, error) {
+	max := s.MaxRevisions
+	if max == 0 {
+		max = DefaultMaxRevisions
+	}
+	pruned := make(map[string]bool)
+	if max < 0 {
+		return pruned, nil
+	}
+
+	// Stats only counts the committed revisions
+	excess := -max
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		excess++
+	}
+	for k, v := c.First(); k != nil && excess > 0; k, v = c.First() {
+		var rev Revision
+		if err := json.Unmarshal(v, &rev); err != nil {
+			return nil, err
+		}
+		for key := range s.fileRefs(contentType, rev.Content) {
+			pruned[key] = true
+		}
+		if err := c.Delete(); err != nil {
+			return nil, err
+		}
+		excess--
+	}
+	return pruned, nil
+}
+
+// deleteHistory removes all revisions of the item
+func deleteHistory(tx *bolt.Tx, contentType, language string, id int64) error {
+	parent, err := historyBucket(tx, contentType, language, id, false)
+	if err != nil || parent == nil {
+		return err
+	}
+	b := tx.Bucket([]byte(HistoryBucket)).Bucket([]byte(contentType)).Bucket([]byte(language))
+	return b.DeleteBucket([]byte(strconv.FormatInt(id, 10)))
+}
+
+func revisionKeyOf(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// getRevision reads a single revision of the item stored under slug
+func (s *Service) getRevision(tx *bolt.Tx, contentType, language, slug string, seq uint64) (*Revision, error) {
+	b, err := s.itemHistory(tx, contentType, language, slug)
+	if err != nil {
+		return nil, err
+	}
+	val := b.Get(revisionKeyOf(seq))
+	if val == nil {
+		return nil, ErrorRevisionNotFound
+	}
+	var rev Revision
+	if err := json.Unmarshal(val, &rev); err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// itemHistory returns the revisions bucket of the item stored under slug
+func (s *Service) itemHistory(tx *bolt.Tx, contentType, language, slug string) (*bolt.Bucket, error) {
+	bb, err := s.bucket(tx, contentType, language)
+	if err != nil {
+		return nil, err
+	}
+	var content map[string]interface{}
+	val := bb.Get([]byte(slug))
+	if val == nil {
+		return nil, api.ErrorNotFound
+	}
+	if err := json.Unmarshal(val, &content); err != nil {
+		return nil, err
+	}
+	id, _ := toInt64(content["id"])
+	b, err := historyBucket(tx, contentType, language, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, ErrorRevisionNotFound
+	}
+	return b, nil
+}
+
+// Revisions - lists the revisions of an item
+func (s *Service) Revisions(ctx context.Context, req *RevisionRequest) (*RevisionsResponse, error) {
+	var resp = RevisionsResponse{Type: req.Type, Language: req.Language, Slug: req.Slug}
+
+	if !s.hasType(req.Type) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := s.itemHistory(tx, req.Type, req.Language, req.Slug)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var rev Revision
+			if err := json.Unmarshal(v, &rev); err != nil {
+				return err
+			}
+			rev.Content = nil
+			resp.Revisions = append(resp.Revisions, rev)
+			return nil
+		})
+	})
+	if err != nil {
+		resp.Err = err.Error()
+	}
+
+	return &resp, nil
+}
+
+// Revision - returns a single revision of an item
+func (s *Service) Revision(ctx context.Context, req *RevisionRequest) (*RevisionResponse, error) {
+	var resp = RevisionResponse{Type: req.Type, Language: req.Language, Slug: req.Slug}
+
+	if !s.hasType(req.Type) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		resp.Revision, err = s.getRevision(tx, req.Type, req.Language, req.Slug, req.Revision)
+		return err
+	})
+	if err != nil {
+		resp.Err = err.Error()
+	}
+
+	return &resp, nil
+}
+
+// Diff - compares two revisions of an item field by field
+func (s *Service) Diff(ctx context.Context, req *RevisionRequest) (*DiffResponse, error) {
+	var resp = DiffResponse{Type: req.Type, Language: req.Language, Slug: req.Slug, From: req.Revision, To: req.To}
+
+	if !s.hasType(req.Type) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		from, err := s.getRevision(tx, req.Type, req.Language, req.Slug, req.Revision)
+		if err != nil {
+			return err
+		}
+		to, err := s.getRevision(tx, req.Type, req.Language, req.Slug, req.To)
+		if err != nil {
+			return err
+		}
+		resp.Changes = diff(from.Content, to.Content)
+		return nil
+	})
+	if err != nil {
+		resp.Err = err.Error()
+	}
+
+	return &resp, nil
+}
+
+// diff lists fields added, removed or modified from a to b
+func diff(a, b map[string]interface{}) []Change {
+	var fields []string
+	for k := range a {
+		fields = append(fields, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := []Change{}
+	for _, field := range fields {
+		if !reflect.DeepEqual(a[field], b[field]) {
+			changes = append(changes, Change{Field: field, From: a[field], To: b[field]})
+		}
+	}
+	return changes
+}
+
+// Rollback - restores the content of an item from a revision, it is
+// checked like an update
+func (s *Service) Rollback(ctx context.Context, req *RevisionRequest) (*api.Response, error) {
+	s.langMu.RLock()
+	defer s.langMu.RUnlock()
+
+	var resp = api.Response{Type: req.Type, Language: req.Language}
+	var current map[string]interface{}
+	var pruned map[string]bool
+
+	if !s.hasType(req.Type) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bb, err := s.bucket(tx, req.Type, req.Language)
+		if err != nil {
+			return err
+		}
+		val := bb.Get([]byte(req.Slug))
+		if val == nil {
+			return api.ErrorNotFound
+		}
+		if err := json.Unmarshal(val, &current); err != nil {
+			return err
+		}
+
+		if err := checkIfMatch(ctx, current); err != nil {
+			return err
+		}
+		if err := checkVersion(ctx, current, nil); err != nil {
+			return err
+		}
+		rev, err := s.getRevision(tx, req.Type, req.Language, req.Slug, req.Revision)
+		if err != nil {
+			return err
+		}
+
+		// Keep the identity and trash state of the current item
+		content := rev.Content
+		for _, k := range []string{"id", "slug", "language", "created_at", "deleted_at", "translation_group"} {
+			content[k] = current[k]
+		}
+		if _, ok := content["status"]; !ok {
+			// Revisions older than the workflow keep the current status
+			content["status"] = current["status"]
+		}
+		if err := s.validate(req.Type, content); err != nil {
+			return err
+		}
+		if err := checkWorkflow(current, content); err != nil {
+			return err
+		}
+		content["updated_at"] = time.Now().Unix()
+		nextVersion(content, itemVersion(current))
+
+		j, err := json.Marshal(content)
+		if err != nil {
+			return err
+		}
+		if err := bb.Put([]byte(req.Slug), j); err != nil {
+			return err
+		}
+		if pruned, err = s.addRevision(ctx, tx, req.Type, req.Language, "rollback", content); err != nil {
+			return err
+		}
+		if err := putGroupMember(tx, req.Type, req.Language, content); err != nil {
+			return err
+		}
+
+		resp.Content = content
+
+		index, err := s.getIndex(req.Type, req.Language)
+		if err != nil {
+			return err
+		}
+		if !isTrashed(content) {
+			if err := index.Index(req.Slug, content); err != nil {
+				return err
+			}
+		}
+		return syncRevision(tx, index, req.Type, req.Language)
+	})
+	if err != nil {
+		resp.Err, resp.Content = validationResponse(err)
+		if err == ErrorConflict {
+			// Let the client show the current item
+			resp.Content = current
+		}
+		return &resp, nil
+	}
+
+	// Drop the cached responses
+	s.cache.invalidate(req.Type, req.Language)
+
+	// Remove the files only referenced by the pruned revisions
+	content, _ := resp.Content.(map[string]interface{})
+	if err := s.deleteReplaced(ctx, req.Type, req.Language, current, content, pruned); err != nil {
+		resp.Err = err.Error()
+	}
+
+	return &resp, nil
+}
+
+// RevisionsEndpoint - creates endpoint for Revisions service
+func RevisionsEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RevisionRequest)
+		return svc.Revisions(ctx, &req)
+	}
+}
+
+// RevisionEndpoint - creates endpoint for Revision service
+func RevisionEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RevisionRequest)
+		return svc.Revision(ctx, &req)
+	}
+}
+
+// DiffEndpoint - creates endpoint for Diff service
+func DiffEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RevisionRequest)
+		return svc.Diff(ctx, &req)
+	}
+}
+
+// RollbackEndpoint - creates endpoint for Rollback service
+func RollbackEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RevisionRequest)
+		return svc.Rollback(ctx, &req)
+	}
+}
+
+// DecodeRevisionReq - decodes the incoming request
+func DecodeRevisionReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request RevisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
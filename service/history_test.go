@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+)
+
+func TestHistoryLanguageStates(t *testing.T) {
+	s := newTestService(t)
+	ctx := WithUser(context.Background(), &User{Name: "e", Role: RoleEditor})
+	s.Create(ctx, &api.CreateRequest{Type: "article", Language: "hi", Slug: "a", Content: map[string]interface{}{"title": "1"}}, false)
+	s.Update(ctx, &api.UpdateRequest{Type: "article", Language: "hi", Slug: "a", Content: map[string]interface{}{"title": "2"}}, false)
+
+	// errs returns the errors of the history requests of the item
+	req := &RevisionRequest{Type: "article", Language: "hi", Slug: "a", Revision: 1, To: 2}
+	errs := func() []string {
+		revisions, _ := s.Revisions(ctx, req)
+		revision, _ := s.Revision(ctx, req)
+		diff, _ := s.Diff(ctx, req)
+		rollback, _ := s.Rollback(ctx, req)
+		return []string{revisions.Err, revision.Err, diff.Err, rollback.Err}
+	}
+
+	tests := []struct {
+		name   string
+		change func()
+		errs   []string
+	}{
+		{"enabled", func() {}, []string{"", "", "", ""}},
+		{"archived", func() { s.ArchiveLanguage(ctx, &LanguageRequest{Language: "hi"}) },
+			[]string{"", "", "", ErrorLanguageArchived.Error()}},
+		{"disabled", func() { s.DisableLanguage(ctx, &LanguageRequest{Language: "hi"}) },
+			[]string{ErrorUnsupportedLanguage.Error(), ErrorUnsupportedLanguage.Error(), ErrorUnsupportedLanguage.Error(), ErrorUnsupportedLanguage.Error()}},
+	}
+	for _, tt := range tests {
+		tt.change()
+		got := errs()
+		for i, op := range []string{"Revisions", "Revision", "Diff", "Rollback"} {
+			if got[i] != tt.errs[i] {
+				t.Errorf("%s: %s error %q, want %q", tt.name, op, got[i], tt.errs[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/disintegration/imaging"
+	// Decode WebP originals
+	_ "golang.org/x/image/webp"
+)
+
+// maxImagePixels limits the size of decoded originals
+const maxImagePixels = 50000000
+
+// maxImageHeader limits the bytes read to find the dimensions of originals
+const maxImageHeader = 1 << 20
+
+// Image errors
+var (
+	ErrorImageSize   = errors.New("Image size not allowed")
+	ErrorImageFit    = errors.New("Invalid image fit")
+	ErrorImageFormat = errors.New("Unsupported image format")
+	ErrorNotAnImage  = errors.New("File is not an image")
+	ErrorImageTooBig = errors.New("Image too large")
+)
+
+// ImageSize is a derivative size, a zero dimension keeps the aspect ratio
+type ImageSize struct {
+	Width  int
+	Height int
+}
+
+// ImageConfig configures the derivatives served by DriveHandler
+type ImageConfig struct {
+	// Sizes allowed, derivatives are disabled if it is empty
+	Sizes []ImageSize
+
+	// CacheDir holds the generated derivatives, "cache/images" by default
+	CacheDir string
+}
+
+// ParseImageSizes parses a comma separated list such as "400x300,800x0"
+func ParseImageSizes(csv string) ([]ImageSize, error) {
+	var sizes []ImageSize
+	for _, s := range strings.Split(csv, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		var size ImageSize
+		if _, err := fmt.Sscanf(s, "%dx%d", &size.Width, &size.Height); err != nil {
+			return nil, fmt.Errorf("Invalid image size %q", s)
+		}
+		if size.Width < 0 || size.Height < 0 || size.Width+size.Height == 0 {
+			return nil, fmt.Errorf("Invalid image size %q", s)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// derivative is a requested variant of an image
+type derivative struct {
+	size   ImageSize
+	fit    string
+	format string
+}
+
+// parseDerivative reads the w, h, fit and format query parameters, nil
+// is returned if none is set
+func (c *ImageConfig) parseDerivative(query url.Values) (*derivative, error) {
+	if query.Get("w") == "" && query.Get("h") == "" && query.Get("fit") == "" && query.Get("format") == "" {
+		return nil, nil
+	}
+
+	var d derivative
+	var err error
+	if v := query.Get("w"); v != "" {
+		if d.size.Width, err = strconv.Atoi(v); err != nil {
+			return nil, ErrorImageSize
+		}
+	}
+	if v := query.Get("h"); v != "" {
+		if d.size.Height, err = strconv.Atoi(v); err != nil {
+			return nil, ErrorImageSize
+		}
+	}
+	// Converting the format without resizing is allowed too
+	allowed := len(c.Sizes) > 0 && d.size == (ImageSize{})
+	for _, size := range c.Sizes {
+		if size == d.size {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return nil, ErrorImageSize
+	}
+
+	switch d.fit = query.Get("fit"); d.fit {
+	case "":
+		d.fit = "contain"
+	case "contain", "cover", "fill":
+	default:
+		return nil, ErrorImageFit
+	}
+
+	switch d.format = strings.ToLower(query.Get("format")); d.format {
+	case "", "png", "gif", "webp":
+	case "jpg", "jpeg":
+		d.format = "jpeg"
+	default:
+		return nil, ErrorImageFormat
+	}
+	return &d, nil
+}
+
+// cachePath returns the file caching derivative d of the original info,
+// the name changes whenever the original is replaced
+func (c *ImageConfig) cachePath(info *FileInfo, d *derivative) string {
+	id := fmt.Sprintf("%s|%d|%d|%dx%d|%s|%s", info.Key, info.Size, info.ModTime.UnixNano(),
+		d.size.Width, d.size.Height, d.fit, d.format)
+	sum := sha256.Sum256([]byte(id))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.CacheDir, name[:2], name)
+}
+
+// render generates derivative d of the original image read from src, its
+// dimensions are checked before the image is decoded
+func (d *derivative) render(src io.Reader) ([]byte, error) {
+	var head bytes.Buffer
+	config, format, err := image.DecodeConfig(io.TeeReader(io.LimitReader(src, maxImageHeader), &head))
+	if err != nil {
+		return nil, ErrorNotAnImage
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, ErrorImageTooBig
+	}
+	img, err := imaging.Decode(io.MultiReader(&head, src), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, ErrorNotAnImage
+	}
+
+	w, h := d.size.Width, d.size.Height
+	switch {
+	case w == 0 && h == 0:
+	case w == 0 || h == 0 || d.fit == "fill":
+		img = imaging.Resize(img, w, h, imaging.Lanczos)
+	case d.fit == "cover":
+		img = imaging.Fill(img, w, h, imaging.Center, imaging.Lanczos)
+	default:
+		img = imaging.Fit(img, w, h, imaging.Lanczos)
+	}
+
+	// Keep the format of the original if it can be encoded
+	if d.format == "" {
+		d.format = format
+	}
+	var buf bytes.Buffer
+	if d.format == "webp" {
+		// WebP derivatives are lossless
+		if err := nativewebp.Encode(&buf, img, nil); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	f, err := imaging.FormatFromExtension(d.format)
+	if err != nil {
+		f = imaging.PNG
+	}
+	if err := imaging.Encode(&buf, img, f, imaging.JPEGQuality(85)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// serveDerivative serves derivative d of the file stored under key, it is
+// generated on the first request and cached on disk
+func (c *ImageConfig) serveDerivative(w http.ResponseWriter, r *http.Request, st Storage, key string, d *derivative) {
+	info, err := st.Stat(r.Context(), key)
+	if err == ErrorFileNotFound || err == ErrorInvalidFileKey {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := c.cachePath(info, d)
+	if _, err := os.Stat(name); err != nil {
+		if err := c.generate(r, st, key, d, name); err != nil {
+			status := http.StatusInternalServerError
+			switch err {
+			case ErrorNotAnImage:
+				status = http.StatusUnsupportedMediaType
+			case ErrorImageTooBig:
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var head [512]byte
+	n, _ := f.Read(head[:])
+	w.Header().Set("Content-Type", http.DetectContentType(head[:n]))
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// generate renders derivative d of key into the cache file name
+func (c *ImageConfig) generate(r *http.Request, st Storage, key string, d *derivative, name string) error {
+	src, err := st.Get(r.Context(), key)
+	if err != nil {
+		return err
+	}
+	out, err := d.render(src)
+	src.Close()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, concurrent requests may render the
+	// same derivative
+	if err := os.MkdirAll(filepath.Dir(name), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(out)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
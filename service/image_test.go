@@ -0,0 +1,144 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pngImage encodes a w x h image
+func pngImage(t *testing.T, w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		img.Set(x, 0, color.NRGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// cacheFiles returns the derivatives cached in dir
+func cacheFiles(dir string) []string {
+	var files []string
+	filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, name)
+		}
+		return nil
+	})
+	return files
+}
+
+func TestParseImageSizes(t *testing.T) {
+	sizes, err := ParseImageSizes(" 400x300, 800x0,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 2 || sizes[0] != (ImageSize{400, 300}) || sizes[1] != (ImageSize{800, 0}) {
+		t.Errorf("ParseImageSizes() = %v", sizes)
+	}
+	for _, csv := range []string{"400", "0x0", "-1x10", "axb"} {
+		if _, err := ParseImageSizes(csv); err == nil {
+			t.Errorf("ParseImageSizes(%q) accepted", csv)
+		}
+	}
+}
+
+func TestDriveHandlerImages(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st := &LocalStorage{Root: filepath.Join(dir, "drive")}
+	images := ImageConfig{
+		Sizes:    []ImageSize{{Width: 40, Height: 20}, {Width: 10}},
+		CacheDir: filepath.Join(dir, "cache"),
+	}
+	handler := DriveHandler(st, images)
+
+	original := pngImage(t, 80, 20)
+	st.Put(ctx, "article/1/a.png", bytes.NewReader(original), int64(len(original)), "image/png")
+	st.Put(ctx, "article/1/a.txt", strings.NewReader("text"), 4, "text/plain")
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	tests := []struct {
+		url    string
+		status int
+		width  int
+		height int
+	}{
+		{"/article/1/a.png", http.StatusOK, 80, 20},
+		{"/article/1/a.png?w=40&h=20", http.StatusOK, 40, 10},
+		{"/article/1/a.png?w=40&h=20&fit=cover", http.StatusOK, 40, 20},
+		{"/article/1/a.png?w=40&h=20&fit=fill", http.StatusOK, 40, 20},
+		{"/article/1/a.png?w=10", http.StatusOK, 10, 3},
+		{"/article/1/a.png?format=jpg", http.StatusOK, 80, 20},
+
+		// Only the configured sizes are rendered
+		{"/article/1/a.png?w=41&h=20", http.StatusBadRequest, 0, 0},
+		{"/article/1/a.png?w=40", http.StatusBadRequest, 0, 0},
+		{"/article/1/a.png?w=ten", http.StatusBadRequest, 0, 0},
+		{"/article/1/a.png?w=40&h=20&fit=crop", http.StatusBadRequest, 0, 0},
+		{"/article/1/a.png?w=40&h=20&format=tiff", http.StatusBadRequest, 0, 0},
+
+		{"/article/1/b.png?w=10", http.StatusNotFound, 0, 0},
+		{"/article/1/a.txt?w=10", http.StatusUnsupportedMediaType, 0, 0},
+	}
+	for _, tt := range tests {
+		w := get(tt.url)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.url, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		config, _, err := image.DecodeConfig(w.Body)
+		if err != nil {
+			t.Errorf("%s: %v", tt.url, err)
+			continue
+		}
+		if config.Width != tt.width || config.Height != tt.height {
+			t.Errorf("%s: size %dx%d, want %dx%d", tt.url, config.Width, config.Height, tt.width, tt.height)
+		}
+	}
+	// Rejected sizes aren't rendered
+	if n := len(cacheFiles(images.CacheDir)); n != 5 {
+		t.Errorf("%d derivatives cached, want 5", n)
+	}
+
+	// Later requests are served from the cache
+	cached := cacheFiles(images.CacheDir)
+	for _, name := range cached {
+		ioutil.WriteFile(name, pngImage(t, 1, 1), 0644)
+	}
+	w := get("/article/1/a.png?w=10")
+	if config, _, err := image.DecodeConfig(w.Body); err != nil || config.Width != 1 {
+		t.Errorf("derivative not served from cache: %v, %v", config, err)
+	}
+
+	// Replacing the original renders new derivatives
+	replaced := pngImage(t, 20, 20)
+	st.Put(ctx, "article/1/a.png", bytes.NewReader(replaced), int64(len(replaced)), "image/png")
+	w = get("/article/1/a.png?w=10")
+	if config, _, err := image.DecodeConfig(w.Body); err != nil || config.Width != 10 || config.Height != 10 {
+		t.Errorf("derivative of replaced original: %v, %v", config, err)
+	}
+	if n := len(cacheFiles(images.CacheDir)); n != len(cached)+1 {
+		t.Errorf("%d derivatives cached, want %d", n, len(cached)+1)
+	}
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/boltdb/bolt"
+)
+
+// MetaBucket holds internal bookkeeping such as index revisions
+const MetaBucket = "_meta"
+
+// revisionKey is the internal index key holding the revision it was built from
+var revisionKey = []byte("_revision")
+
+func (s *Service) indexPath(contentType, language string) string {
+	return filepath.Join(s.IndexFile, contentType, language)
+}
+
+// openIndex opens the persistent index for content type and language,
+// creating it if missing. An in-memory index is used if IndexFile is empty.
+func (s *Service) openIndex(contentType, language string) (bleve.Index, error) {
+	m, err := s.newIndexMapping(contentType, language)
+	if err != nil {
+		return nil, err
+	}
+	if s.IndexFile == "" {
+		return bleve.NewMemOnly(m)
+	}
+	signature, err := mappingSignature(m)
+	if err != nil {
+		return nil, err
+	}
+
+	path := s.indexPath(contentType, language)
+	index, err := bleve.Open(path)
+	if err == nil {
+		if v, err := index.GetInternal(signatureKey); err == nil && bytes.Equal(v, signature) {
+			return index, nil
+		}
+		// The analyzers changed, the index will be rebuilt from the database
+		log.Printf("Mapping of index %s/%s changed", contentType, language)
+		if err := index.Close(); err != nil {
+			return nil, err
+		}
+	}
+	// Missing, unreadable or outdated index
+	return newIndex(path, m)
+}
+
+// newIndex creates an empty index with mapping m at path, replacing any
+// index found there. The index is kept in memory if path is empty.
+func newIndex(path string, m mapping.IndexMapping) (bleve.Index, error) {
+	if path == "" {
+		return bleve.NewMemOnly(m)
+	}
+	signature, err := mappingSignature(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModeDir|os.ModePerm); err != nil {
+		return nil, err
+	}
+	index, err := bleve.New(path, m)
+	if err != nil {
+		return nil, err
+	}
+	if err := index.SetInternal(signatureKey, signature); err != nil {
+		index.Close()
+		return nil, err
+	}
+	return index, nil
+}
+
+// fillIndex indexes the items of content type and language stored in tx,
+// the revision of the database is recorded once all items are indexed
+func fillIndex(tx *bolt.Tx, contentType, language string, index bleve.Index) error {
+	bb, err := getBucket(tx, contentType, language)
+	if err != nil {
+		return err
+	}
+	batch := index.NewBatch()
+	c := bb.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var item map[string]interface{}
+		if err := json.Unmarshal(v, &item); err != nil {
+			return err
+		}
+		if isTrashed(item) {
+			continue
+		}
+		if err := batch.Index(string(k), item); err != nil {
+			return err
+		}
+		if batch.Size() >= 1000 {
+			if err := index.Batch(batch); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	batch.SetInternal(revisionKey, encodeRevision(dbRevision(tx, contentType, language)))
+	return index.Batch(batch)
+}
+
+// loadIndex opens the index of content type and language and rebuilds it
+// from tx if it is stale. It is used for indexes not served yet, no write
+// can reach them.
+func (s *Service) loadIndex(tx *bolt.Tx, contentType, language string) (bleve.Index, error) {
+	index, err := s.openIndex(contentType, language)
+	if err != nil {
+		return nil, err
+	}
+	if isCurrent(tx, index, contentType, language) {
+		return index, nil
+	}
+
+	log.Printf("Rebuilding index %s/%s", contentType, language)
+	if s.IndexFile != "" {
+		// Discard the documents of the stale index
+		if err := index.Close(); err != nil {
+			return nil, err
+		}
+		m, err := s.newIndexMapping(contentType, language)
+		if err != nil {
+			return nil, err
+		}
+		if index, err = newIndex(s.indexPath(contentType, language), m); err != nil {
+			return nil, err
+		}
+	}
+	if err := fillIndex(tx, contentType, language, index); err != nil {
+		index.Close()
+		return nil, err
+	}
+	return index, nil
+}
+
+// buildIndex builds a new index of content type and language with mapping
+// m from tx. It is served by swapIndex once tx is committed, or dropped by
+// discardIndex if tx fails.
+func (s *Service) buildIndex(tx *bolt.Tx, contentType, language string, m mapping.IndexMapping) (bleve.Index, error) {
+	var path string
+	if s.IndexFile != "" {
+		path = s.indexPath(contentType, language) + ".new"
+	}
+	index, err := newIndex(path, m)
+	if err != nil {
+		return nil, err
+	}
+	if err := fillIndex(tx, contentType, language, index); err != nil {
+		s.discardIndex(contentType, language, index)
+		return nil, err
+	}
+	return index, nil
+}
+
+// discardIndex closes and removes an index built by buildIndex
+func (s *Service) discardIndex(contentType, language string, index bleve.Index) {
+	index.Close()
+	if s.IndexFile != "" {
+		os.RemoveAll(s.indexPath(contentType, language) + ".new")
+	}
+}
+
+// swapIndex serves an index built by buildIndex in place of the current
+// one. The caller must hold langMu, which holds off the requests using the
+// current index and the writes the new one could miss.
+func (s *Service) swapIndex(contentType, language string, index bleve.Index) error {
+	s.mu.Lock()
+	previous := s.index[contentType][language]
+	s.index[contentType][language] = index
+	s.mu.Unlock()
+	if previous != nil {
+		if err := previous.Close(); err != nil {
+			log.Printf("Closing index %s/%s: %v", contentType, language, err)
+		}
+	}
+	if s.IndexFile == "" {
+		return nil
+	}
+	// The new index stays open while it is moved in place
+	if err := os.RemoveAll(s.indexPath(contentType, language)); err != nil {
+		return err
+	}
+	return os.Rename(s.indexPath(contentType, language)+".new", s.indexPath(contentType, language))
+}
+
+// isCurrent reports whether index was built from the revision of content
+// type and language in tx
+func isCurrent(tx *bolt.Tx, index bleve.Index, contentType, language string) bool {
+	r, ok := indexRevision(index)
+	return ok && r == dbRevision(tx, contentType, language)
+}
+
+func revisionName(contentType, language string) []byte {
+	return []byte(contentType + "/" + language)
+}
+
+// dbRevision returns the revision of content type and language in database
+func dbRevision(tx *bolt.Tx, contentType, language string) uint64 {
+	b := tx.Bucket([]byte(MetaBucket))
+	if b == nil {
+		return 0
+	}
+	v := b.Get(revisionName(contentType, language))
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+// bumpRevision increments the revision of content type and language in
+// database and returns the new value. It must be called by every write.
+func bumpRevision(tx *bolt.Tx, contentType, language string) (uint64, error) {
+	b, err := tx.CreateBucketIfNotExists([]byte(MetaBucket))
+	if err != nil {
+		return 0, err
+	}
+	rev := dbRevision(tx, contentType, language) + 1
+	if err := b.Put(revisionName(contentType, language), encodeRevision(rev)); err != nil {
+		return 0, err
+	}
+	return rev, nil
+}
+
+// indexRevision returns the database revision the index was built from
+func indexRevision(index bleve.Index) (uint64, bool) {
+	v, err := index.GetInternal(revisionKey)
+	if err != nil || len(v) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(v), true
+}
+
+func encodeRevision(rev uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, rev)
+	return b
+}
+
+// syncRevision bumps the database revision and records it in the index
+func syncRevision(tx *bolt.Tx, index bleve.Index, contentType, language string) error {
+	rev, err := bumpRevision(tx, contentType, language)
+	if err != nil {
+		return err
+	}
+	return index.SetInternal(revisionKey, encodeRevision(rev))
+}
@@ -1,34 +1,60 @@
 package service
 
 import (
-	"encoding/json"
 	"errors"
 	"log"
 	"time"
 
-	"git.urantiatech.com/cloudcms/cloudcms/api"
-	"git.urantiatech.com/cloudcms/cloudcms/item"
 	"github.com/blevesearch/bleve"
 	"github.com/boltdb/bolt"
-	"github.com/patrickmn/go-cache"
 )
 
-// Initialize function
-func Initialize(dbFile string) error {
+// Initialize opens the database and the indexes, the database stays open
+// until Close is called
+func (s *Service) Initialize() error {
 	var err error
-	var db *bolt.DB
 
-	DBFile = dbFile
-
-	Index = make(map[string]map[string]bleve.Index)
+	s.index = make(map[string]map[string]bleve.Index)
+	s.fields = make(map[string][]Field)
+	if s.Storage == nil {
+		s.Storage = &LocalStorage{Root: "drive"}
+	}
 
-	// Create databse if it doesn't exist.
-	db, err = bolt.Open(DBFile, 0644, nil)
+	// Create databse if it doesn't exist. Fail instead of waiting forever
+	// if another process holds the lock.
+	s.db, err = bolt.Open(s.DBFile, 0644, &bolt.Options{Timeout: time.Second})
 	if err != nil {
 		return err
 	}
-	err = db.Update(func(tx *bolt.Tx) error {
-		for t := range item.Types {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		// Content stored before the workflow existed is migrated once
+		meta, err := tx.CreateBucketIfNotExists([]byte(MetaBucket))
+		if err != nil {
+			return err
+		}
+		migrate := meta.Get(workflowKey) == nil
+		if err := meta.Put(workflowKey, []byte{1}); err != nil {
+			return err
+		}
+
+		// Apply the languages enabled, archived or disabled at runtime
+		if err := s.loadLanguages(meta); err != nil {
+			return err
+		}
+
+		// Load the content types, the field definitions are needed by
+		// the index mappings
+		types, err := loadTypes(tx, meta)
+		if err != nil {
+			return err
+		}
+		for _, def := range types {
+			s.fields[def.Name] = compileFields(def.Fields)
+		}
+
+		for _, def := range types {
+			t := def.Name
+
 			// Create bucket for content type
 			b, err := tx.CreateBucketIfNotExists([]byte(t))
 			if err != nil {
@@ -36,26 +62,27 @@ func Initialize(dbFile string) error {
 			}
 
 			// Create in-memory index for content type
-			if _, ok := Index[t]; !ok {
-				Index[t] = make(map[string]bleve.Index)
+			if _, ok := s.index[t]; !ok {
+				s.index[t] = make(map[string]bleve.Index)
 			}
 
-			for _, l := range Languages {
+			for _, l := range s.Languages {
 				// Create nested bucket for each supported language
 				_, err := b.CreateBucketIfNotExists([]byte(l.String()))
 				if err != nil {
 					return err
 				}
 
-				// Create in-memory index for each supported language
-				if _, ok := Index[t][l.String()]; !ok {
-
-					mapping := bleve.NewIndexMapping()
-					if item.ContentMapping != nil {
-						mapping.DefaultMapping = item.ContentMapping
+				// Publish content stored before the workflow existed
+				if migrate {
+					if err := s.migrateStatus(tx, t, l.String()); err != nil {
+						return err
 					}
+				}
 
-					Index[t][l.String()], err = bleve.NewMemOnly(mapping)
+				// Open index for each supported language
+				if _, ok := s.index[t][l.String()]; !ok {
+					s.index[t][l.String()], err = s.openIndex(t, l.String())
 					if err != nil {
 						return err
 					}
@@ -63,72 +90,113 @@ func Initialize(dbFile string) error {
 			}
 
 		}
+
+		// Index the translation groups of content stored before
+		if meta.Get(groupsKey) == nil {
+			if err := buildGroups(tx, types); err != nil {
+				return err
+			}
+			if err := meta.Put(groupsKey, []byte{1}); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
-	db.Close()
 	if err != nil {
+		s.db.Close()
+		s.db = nil
 		return err
 	}
 
-	// Create a cache with a default expiration time of 5 minutes, and which
-	// purges expired items every 10 minutes
-	RespCache = cache.New(5*time.Minute, 10*time.Minute)
+	// Create a cache of read responses, expired items are purged every
+	// second TTL
+	s.cache = newRespCache(s.CacheTTL)
 
 	return nil
 }
 
-func RebuildIndex() error {
-	// Open database in read-only mode to allow multipe readers
-	options := bolt.Options{ReadOnly: true}
-	db, err := bolt.Open(DBFile, 0644, &options)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
+// RebuildIndex reindexes all content whose index is missing or stale
+func (s *Service) RebuildIndex() error {
+	s.langMu.Lock()
+	defer s.langMu.Unlock()
 
 	// Rebuild index for all Content Types
-	for t := range item.Types {
-		// Index all available items
-		if err := db.View(func(tx *bolt.Tx) error {
-			// Load all content of all supported languages
-			for _, l := range Languages {
-				bb, err := getBucket(tx, t, l.String())
+	for t := range s.indexes() {
+		// Load all content of all supported languages
+		for _, l := range s.languages() {
+			// Skip the index if it was built from the current revision
+			var current bool
+			if err := s.db.View(func(tx *bolt.Tx) error {
+				index, err := s.getIndex(t, l.String())
 				if err != nil {
 					return err
 				}
-				c := bb.Cursor()
-				if c == nil {
-					return errors.New("Unknown Error")
-				}
-				// Iterate over the cursor and index the value
-				for k, v := c.First(); k != nil; k, v = c.Next() {
-					var resp api.Response
-					slug := string(k[:])
-					err = json.Unmarshal(v, &resp.Content)
-					if err != nil {
-						return err
-					}
+				current = isCurrent(tx, index, t, l.String())
+				return nil
+			}); err != nil {
+				return err
+			}
+			if current {
+				continue
+			}
 
-					index, err := getIndex(t, l.String())
-					if err != nil {
-						return err
-					}
-					item := resp.Content.(map[string]interface{})
-					err = index.Index(slug, item)
-					if err != nil {
-						return err
-					}
+			// Writes wait for the new index to be served
+			var rebuilt bleve.Index
+			if err := s.db.Update(func(tx *bolt.Tx) error {
+				index, err := s.getIndex(t, l.String())
+				if err != nil {
+					return err
+				}
+				if isCurrent(tx, index, t, l.String()) {
+					return nil
+				}
+				log.Printf("Rebuilding index %s/%s", t, l.String())
+				m, err := s.newIndexMapping(t, l.String())
+				if err != nil {
+					return err
+				}
+				rebuilt, err = s.buildIndex(tx, t, l.String(), m)
+				return err
+			}); err != nil {
+				if rebuilt != nil {
+					s.discardIndex(t, l.String(), rebuilt)
+				}
+				return err
+			}
+			if rebuilt != nil {
+				if err := s.swapIndex(t, l.String(), rebuilt); err != nil {
+					return err
 				}
 			}
-
-			return nil
-		}); err != nil {
-			log.Fatal(err)
 		}
 	}
 	return nil
 }
 
+// Close stops the scheduler, flushes and closes all indexes and the database
+func (s *Service) Close() error {
+	var err error
+	s.stopScheduler()
+	s.mu.Lock()
+	for _, languages := range s.index {
+		for _, index := range languages {
+			if e := index.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	s.index = nil
+	s.mu.Unlock()
+	if s.db == nil {
+		return err
+	}
+	if e := s.db.Close(); e != nil && err == nil {
+		err = e
+	}
+	s.db = nil
+	return err
+}
+
 func getBucket(tx *bolt.Tx, contentType, language string) (*bolt.Bucket, error) {
 	b := tx.Bucket([]byte(contentType))
 	if b == nil {
@@ -136,17 +204,19 @@ func getBucket(tx *bolt.Tx, contentType, language string) (*bolt.Bucket, error)
 	}
 	bb := b.Bucket([]byte(language))
 	if bb == nil {
-		return nil, errors.New("Unsupported language")
+		return nil, ErrorUnsupportedLanguage
 	}
 	return bb, nil
 }
 
-func getIndex(contentType, language string) (bleve.Index, error) {
-	if _, ok := Index[contentType]; !ok {
+func (s *Service) getIndex(contentType, language string) (bleve.Index, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.index[contentType]; !ok {
 		return nil, errors.New("Invalid content type")
 	}
-	if _, ok := Index[contentType][language]; !ok {
+	if _, ok := s.index[contentType][language]; !ok {
 		return nil, errors.New("Unsupported Language")
 	}
-	return Index[contentType][language], nil
+	return s.index[contentType][language], nil
 }
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"golang.org/x/text/language"
+)
+
+// putJSON stores v under the bucket path and key
+func putJSON(tx *bolt.Tx, path []string, key string, v interface{}) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(path[0]))
+	for _, name := range path[1:] {
+		if err != nil {
+			return err
+		}
+		b, err = b.CreateBucketIfNotExists([]byte(name))
+	}
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), j)
+}
+
+// storedStatus returns the status of an item in the database
+func storedStatus(s *Service, slug string) interface{} {
+	var content map[string]interface{}
+	s.db.View(func(tx *bolt.Tx) error {
+		return json.Unmarshal(tx.Bucket([]byte("article")).Bucket([]byte("en")).Get([]byte(slug)), &content)
+	})
+	return content["status"]
+}
+
+func TestInitializeMigrations(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "db")
+
+	// A database written before the workflow and translation groups
+	db, err := bolt.Open(dbFile, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := putJSON(tx, []string{TypesBucket}, "article", TypeDefinition{Name: "article"}); err != nil {
+			return err
+		}
+		if err := putJSON(tx, []string{"article", "en"}, "a", map[string]interface{}{"id": 1, "slug": "a", "title": "A"}); err != nil {
+			return err
+		}
+		return putJSON(tx, []string{"article", "en"}, "b", map[string]interface{}{"id": 2, "slug": "b", "status": StatusDraft, "translation_group": "en-7"})
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newService := func() *Service {
+		return &Service{
+			DBFile:    dbFile,
+			Languages: []language.Tag{language.English, language.Hindi},
+			Storage:   &LocalStorage{Root: filepath.Join(dir, "drive")},
+		}
+	}
+	s := newService()
+	if err := s.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Items without status were published, others kept
+	if status := storedStatus(s, "a"); status != StatusPublished {
+		t.Errorf("status of legacy item %v, want %s", status, StatusPublished)
+	}
+	if status := storedStatus(s, "b"); status != StatusDraft {
+		t.Errorf("status of draft %v, want %s", status, StatusDraft)
+	}
+	s.db.View(func(tx *bolt.Tx) error {
+		members, err := readGroup(tx, "article", "en-7")
+		if err != nil || members["en"].Slug != "b" {
+			t.Errorf("group en-7: %v, %v", members, err)
+		}
+		if tx.Bucket([]byte("article")).Bucket([]byte("hi")) == nil {
+			t.Error("bucket of article/hi not created")
+		}
+		return nil
+	})
+
+	// The database is locked by the service owning it
+	other := newService()
+	if err := other.Initialize(); err == nil {
+		other.Close()
+		t.Fatal("database opened twice")
+	}
+	if other.db != nil {
+		t.Error("database handle kept after failed Initialize")
+	}
+
+	// Migrations run once
+	s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, []string{"article", "en"}, "c", map[string]interface{}{"id": 3, "slug": "c"})
+	})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = newService()
+	if err := s.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if status := storedStatus(s, "c"); status != nil {
+		t.Errorf("status of item stored after the migration %v", status)
+	}
+}
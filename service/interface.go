@@ -3,18 +3,16 @@ package service
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"git.urantiatech.com/cloudcms/cloudcms/api"
-	"github.com/patrickmn/go-cache"
+	"github.com/blevesearch/bleve"
+	"github.com/boltdb/bolt"
 	"golang.org/x/text/language"
 )
 
-// Languages supported
-var Languages []language.Tag
-
 // Interface definition
 type Interface interface {
 	// Normal DB operations
@@ -30,21 +28,94 @@ type Interface interface {
 }
 
 // Service struct for accessing services
-type Service struct{}
+type Service struct {
+	// DBFile is the path of database file
+	DBFile string
+
+	// IndexFile is the path of the persistent index directory, indexes are
+	// kept in memory and rebuilt at every start if it is empty
+	IndexFile string
+
+	// Languages supported
+	Languages []language.Tag
+
+	// UploadDir holds the partial files of resumable uploads
+	UploadDir string
+
+	// StrictFields rejects content fields missing from the field definitions
+	StrictFields bool
+
+	// Storage holds the uploaded files, the local "drive" directory is used
+	// if it is nil
+	Storage Storage
+
+	// CacheTTL is the expiration of cached responses, DefaultCacheTTL if
+	// zero, caching is disabled if it is negative
+	CacheTTL time.Duration
+
+	// Fallback serves the content of fallback languages when an item is
+	// missing in the requested language
+	Fallback bool
+
+	// MaxRevisions is the number of revisions kept per item, the oldest
+	// are pruned with the files only they reference. DefaultMaxRevisions
+	// is used if zero and all revisions are kept if it is negative.
+	MaxRevisions int
+
+	// Fallbacks map[Language] fallback chain, languages without a chain
+	// fall back to their BCP 47 parents and then to the first language
+	Fallbacks map[string][]string
 
-// RespCache caches the response
-var RespCache *cache.Cache
+	// mu guards Languages, index, fields and states which change when
+	// languages or content types are managed at runtime, langMu
+	// serializes these changes. Requests using an index hold langMu
+	// shared so it isn't closed or replaced under them.
+	mu     sync.RWMutex
+	langMu sync.RWMutex
+
+	// states map[Language] state of the languages changed at runtime
+	states map[string]string
+
+	// db is shared by all requests, it is opened by Initialize
+	db *bolt.DB
+
+	// index map[ContentType]map[Language]bleve.Index
+	index map[string]map[string]bleve.Index
+
+	// fields map[ContentType] field definitions used for validation
+	fields map[string][]Field
+
+	// cache caches the responses of read requests
+	cache *respCache
+
+	// uploads serializes the chunks of each resumable upload
+	uploads tokenLocks
+
+	// stop and done control the scheduler
+	stop chan struct{}
+	done chan struct{}
+}
 
 // Encode the response
 func Encode(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if writeValidators(ctx, w, response) {
+		return nil
+	}
 	return json.NewEncoder(w).Encode(response)
 }
 
-func PrintCreateReq(r *api.CreateRequest) {
-	json, err := json.MarshalIndent(r, "  ", "  ")
-	if err != nil {
-		log.Fatal(err)
+// EncodeError encodes errors raised while decoding malformed requests and
+// by endpoint middlewares
+func EncodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	status := http.StatusBadRequest
+	switch err {
+	case ErrorUnauthorized, ErrorInvalidToken:
+		status = http.StatusUnauthorized
+	case ErrorForbidden:
+		status = http.StatusForbidden
 	}
-	fmt.Println(string(json))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 }
@@ -0,0 +1,479 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/blevesearch/bleve"
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/text/language"
+)
+
+// Language states, archived languages are served read-only and disabled
+// languages are not served but their content is kept
+const (
+	LanguageEnabled  = "enabled"
+	LanguageArchived = "archived"
+	LanguageDisabled = "disabled"
+)
+
+// languagesKey is the MetaBucket key holding the states of the languages
+// changed at runtime
+var languagesKey = []byte("languages")
+
+// Language errors
+var (
+	ErrorInvalidLanguage     = errors.New("Invalid language")
+	ErrorUnsupportedLanguage = errors.New("Unsupported language")
+	ErrorLanguageArchived    = errors.New("Language is archived")
+	ErrorLastLanguage        = errors.New("Last language can't be disabled")
+)
+
+// LanguageRequest changes the state of Language, a language being enabled
+// is seeded from Source if provided
+type LanguageRequest struct {
+	Language string `json:"language"`
+	Source   string `json:"source"`
+}
+
+// LanguageState is the state of a language
+type LanguageState struct {
+	Language string `json:"language"`
+	State    string `json:"state"`
+}
+
+// LanguagesResponse lists the states of all known languages
+type LanguagesResponse struct {
+	Languages []LanguageState `json:"languages"`
+	Seeded    int             `json:"seeded,omitempty"`
+	Err       string          `json:"error,omitempty"`
+}
+
+// languages returns the served languages, the first one is the default
+func (s *Service) languages() []language.Tag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]language.Tag(nil), s.Languages...)
+}
+
+// hasType reports whether contentType is a known content type
+func (s *Service) hasType(contentType string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.index[contentType]
+	return ok
+}
+
+// indexes returns a copy of the indexes of all content types and languages
+func (s *Service) indexes() map[string]map[string]bleve.Index {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	indexes := make(map[string]map[string]bleve.Index)
+	for t, languages := range s.index {
+		indexes[t] = make(map[string]bleve.Index)
+		for l, index := range languages {
+			indexes[t][l] = index
+		}
+	}
+	return indexes
+}
+
+// isArchived reports whether writes to language are rejected
+func (s *Service) isArchived(language string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.states[language] == LanguageArchived
+}
+
+// bucket returns the bucket of content type and language for a request,
+// languages which aren't served are unsupported and archived ones are
+// read-only
+func (s *Service) bucket(tx *bolt.Tx, contentType, language string) (*bolt.Bucket, error) {
+	if !s.isEnabled(language) {
+		return nil, ErrorUnsupportedLanguage
+	}
+	if tx.Writable() && s.isArchived(language) {
+		return nil, ErrorLanguageArchived
+	}
+	return getBucket(tx, contentType, language)
+}
+
+// loadLanguages applies the language states saved in meta to Languages
+func (s *Service) loadLanguages(meta *bolt.Bucket) error {
+	s.states = make(map[string]string)
+	for _, l := range s.Languages {
+		s.states[l.String()] = LanguageEnabled
+	}
+
+	var saved []LanguageState
+	if v := meta.Get(languagesKey); v != nil {
+		if err := json.Unmarshal(v, &saved); err != nil {
+			return err
+		}
+	}
+	for _, ls := range saved {
+		tag, err := language.Parse(ls.Language)
+		if err != nil {
+			continue
+		}
+		if _, ok := s.states[tag.String()]; !ok && ls.State != LanguageDisabled {
+			s.Languages = append(s.Languages, tag)
+		}
+		s.states[tag.String()] = ls.State
+	}
+
+	// Languages disabled at runtime stay disabled
+	var served []language.Tag
+	for _, l := range s.Languages {
+		if s.states[l.String()] != LanguageDisabled {
+			served = append(served, l)
+		}
+	}
+	s.Languages = served
+	return saveLanguages(meta, s.Languages, s.states)
+}
+
+// saveLanguages saves the states of languages in meta
+func saveLanguages(meta *bolt.Bucket, languages []language.Tag, states map[string]string) error {
+	j, err := json.Marshal(languageStates(languages, states))
+	if err != nil {
+		return err
+	}
+	return meta.Put(languagesKey, j)
+}
+
+// languageStates returns the states of the served languages followed by
+// the disabled ones
+func languageStates(languages []language.Tag, states map[string]string) []LanguageState {
+	var list []LanguageState
+	served := make(map[string]bool)
+	for _, l := range languages {
+		list = append(list, LanguageState{Language: l.String(), State: states[l.String()]})
+		served[l.String()] = true
+	}
+	var disabled []string
+	for l := range states {
+		if !served[l] {
+			disabled = append(disabled, l)
+		}
+	}
+	sort.Strings(disabled)
+	for _, l := range disabled {
+		list = append(list, LanguageState{Language: l, State: states[l]})
+	}
+	return list
+}
+
+// copyLanguages returns copies of Languages and states to be changed and
+// swapped in, the caller must hold langMu
+func (s *Service) copyLanguages() ([]language.Tag, map[string]string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	states := make(map[string]string)
+	for l, state := range s.states {
+		states[l] = state
+	}
+	return append([]language.Tag(nil), s.Languages...), states
+}
+
+// storeLanguages saves the states of languages in the database
+func (s *Service) storeLanguages(languages []language.Tag, states map[string]string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(MetaBucket))
+		if err != nil {
+			return err
+		}
+		return saveLanguages(meta, languages, states)
+	})
+}
+
+// setLanguageState saves the state of a served language, then applies it
+func (s *Service) setLanguageState(code, state string) error {
+	languages, states := s.copyLanguages()
+	states[code] = state
+	if err := s.storeLanguages(languages, states); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.states = states
+	s.mu.Unlock()
+	return nil
+}
+
+// ListLanguages - returns the states of all languages
+func (s *Service) ListLanguages(ctx context.Context) (*LanguagesResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &LanguagesResponse{Languages: languageStates(s.Languages, s.states)}, nil
+}
+
+// EnableLanguage - serves a new or disabled language, it is seeded with
+// drafts copied from the source language
+func (s *Service) EnableLanguage(ctx context.Context, req *LanguageRequest) (*LanguagesResponse, error) {
+	var resp LanguagesResponse
+
+	tag, err := language.Parse(req.Language)
+	if err != nil {
+		resp.Err = ErrorInvalidLanguage.Error()
+		return &resp, nil
+	}
+	code := tag.String()
+	if req.Source != "" && !s.isEnabled(req.Source) {
+		resp.Err = ErrorUnsupportedLanguage.Error()
+		return &resp, nil
+	}
+
+	s.langMu.Lock()
+	if !s.isEnabled(code) {
+		if err = s.openLanguage(tag); err == nil {
+			log.Printf("Enabled language %s", code)
+		}
+	} else {
+		err = s.setLanguageState(code, LanguageEnabled)
+	}
+	s.langMu.Unlock()
+	if err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+
+	// Seeding creates items like other requests, holding langMu shared
+	if req.Source != "" && req.Source != code {
+		resp.Seeded, err = s.seedLanguage(ctx, req.Source, code)
+		if err != nil {
+			resp.Err = err.Error()
+		}
+	}
+
+	list, _ := s.ListLanguages(ctx)
+	resp.Languages = list.Languages
+	return &resp, nil
+}
+
+// openLanguage creates the buckets and indexes of a language for every
+// content type, then serves it
+func (s *Service) openLanguage(tag language.Tag) error {
+	code := tag.String()
+	languages, states := s.copyLanguages()
+	languages = append(languages, tag)
+	states[code] = LanguageEnabled
+
+	types := s.indexes()
+	indexes := make(map[string]bleve.Index)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for t := range types {
+			b, err := tx.CreateBucketIfNotExists([]byte(t))
+			if err != nil {
+				return err
+			}
+			if _, err := b.CreateBucketIfNotExists([]byte(code)); err != nil {
+				return err
+			}
+			// Indexes of a language disabled before are reused if current
+			if indexes[t], err = s.loadIndex(tx, t, code); err != nil {
+				return err
+			}
+		}
+
+		meta, err := tx.CreateBucketIfNotExists([]byte(MetaBucket))
+		if err != nil {
+			return err
+		}
+		return saveLanguages(meta, languages, states)
+	})
+	if err != nil {
+		for _, index := range indexes {
+			if index != nil {
+				index.Close()
+			}
+		}
+		return err
+	}
+
+	// Serve the language once its indexes are complete
+	s.mu.Lock()
+	for t, index := range indexes {
+		s.index[t][code] = index
+	}
+	s.Languages = languages
+	s.states = states
+	s.mu.Unlock()
+	return nil
+}
+
+// seedLanguage copies the items of source missing in target as drafts of
+// the same translation group
+func (s *Service) seedLanguage(ctx context.Context, source, target string) (int, error) {
+	seeded := 0
+	for t := range s.indexes() {
+		var slugs []string
+		err := s.db.View(func(tx *bolt.Tx) error {
+			bb, err := s.bucket(tx, t, source)
+			if err != nil {
+				return err
+			}
+			return bb.ForEach(func(k, v []byte) error {
+				var content map[string]interface{}
+				if err := json.Unmarshal(v, &content); err != nil {
+					return err
+				}
+				if !isTrashed(content) {
+					slugs = append(slugs, string(k))
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return seeded, err
+		}
+
+		for _, slug := range slugs {
+			resp, err := s.Translate(ctx, &TranslateRequest{
+				Type:     t,
+				Language: source,
+				Slug:     slug,
+				Target:   target,
+				Content:  map[string]interface{}{"status": StatusDraft},
+			})
+			if err != nil {
+				return seeded, err
+			}
+			if resp.Err == ErrorTranslationExists.Error() {
+				continue
+			}
+			if resp.Err != "" {
+				return seeded, errors.New(resp.Err)
+			}
+			seeded++
+		}
+	}
+	return seeded, nil
+}
+
+// DisableLanguage - stops serving a language, its content is kept and
+// served again once the language is enabled
+func (s *Service) DisableLanguage(ctx context.Context, req *LanguageRequest) (*LanguagesResponse, error) {
+	var resp LanguagesResponse
+
+	s.langMu.Lock()
+	defer s.langMu.Unlock()
+
+	code := req.Language
+	if !s.isEnabled(code) {
+		resp.Err = ErrorUnsupportedLanguage.Error()
+		return &resp, nil
+	}
+	if len(s.languages()) == 1 {
+		resp.Err = ErrorLastLanguage.Error()
+		return &resp, nil
+	}
+
+	languages, states := s.copyLanguages()
+	var served []language.Tag
+	for _, l := range languages {
+		if l.String() != code {
+			served = append(served, l)
+		}
+	}
+	states[code] = LanguageDisabled
+	if err := s.storeLanguages(served, states); err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+
+	var closing []bleve.Index
+	s.mu.Lock()
+	s.Languages = served
+	s.states = states
+	for t := range s.index {
+		closing = append(closing, s.index[t][code])
+		delete(s.index[t], code)
+	}
+	s.mu.Unlock()
+
+	for _, index := range closing {
+		if err := index.Close(); err != nil {
+			resp.Err = err.Error()
+		}
+	}
+	for t := range s.indexes() {
+		s.cache.invalidate(t, code)
+	}
+	log.Printf("Disabled language %s", code)
+
+	list, _ := s.ListLanguages(ctx)
+	resp.Languages = list.Languages
+	return &resp, nil
+}
+
+// ArchiveLanguage - serves a language read-only
+func (s *Service) ArchiveLanguage(ctx context.Context, req *LanguageRequest) (*LanguagesResponse, error) {
+	var resp LanguagesResponse
+
+	s.langMu.Lock()
+	defer s.langMu.Unlock()
+
+	if !s.isEnabled(req.Language) {
+		resp.Err = ErrorUnsupportedLanguage.Error()
+		return &resp, nil
+	}
+	if err := s.setLanguageState(req.Language, LanguageArchived); err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+	log.Printf("Archived language %s", req.Language)
+
+	list, _ := s.ListLanguages(ctx)
+	resp.Languages = list.Languages
+	return &resp, nil
+}
+
+// LanguagesEndpoint - creates endpoint for ListLanguages service
+func LanguagesEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		return svc.ListLanguages(ctx)
+	}
+}
+
+// EnableLanguageEndpoint - creates endpoint for EnableLanguage service
+func EnableLanguageEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(LanguageRequest)
+		return svc.EnableLanguage(ctx, &req)
+	}
+}
+
+// DisableLanguageEndpoint - creates endpoint for DisableLanguage service
+func DisableLanguageEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(LanguageRequest)
+		return svc.DisableLanguage(ctx, &req)
+	}
+}
+
+// ArchiveLanguageEndpoint - creates endpoint for ArchiveLanguage service
+func ArchiveLanguageEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(LanguageRequest)
+		return svc.ArchiveLanguage(ctx, &req)
+	}
+}
+
+// DecodeLanguagesReq - decodes the incoming request
+func DecodeLanguagesReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+// DecodeLanguageReq - decodes the incoming request
+func DecodeLanguageReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request LanguageRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
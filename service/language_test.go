@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	"github.com/blevesearch/bleve"
+)
+
+func TestDisableLanguageInUse(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	if resp, _ := s.Create(ctx, &api.CreateRequest{Type: "article", Language: "hi", Slug: "a", Content: map[string]interface{}{"title": "a"}}, false); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+
+	// A request searching Hindi holds langMu shared like List and Search
+	s.langMu.RLock()
+	index, err := s.getIndex("article", "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled := make(chan *LanguagesResponse)
+	go func() {
+		resp, _ := s.DisableLanguage(ctx, &LanguageRequest{Language: "hi"})
+		disabled <- resp
+	}()
+	select {
+	case <-disabled:
+		t.Fatal("language disabled while its index was in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if res, err := index.Search(bleve.NewSearchRequest(bleve.NewMatchAllQuery())); err != nil || res.Total != 1 {
+		t.Errorf("search of index in use: %v, %v", res, err)
+	}
+	s.langMu.RUnlock()
+
+	if resp := <-disabled; resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if _, err := index.Search(bleve.NewSearchRequest(bleve.NewMatchAllQuery())); err == nil {
+		t.Error("index of disabled language still open")
+	}
+	if resp, _ := s.Create(ctx, &api.CreateRequest{Type: "article", Language: "hi", Slug: "b", Content: map[string]interface{}{"title": "b"}}, false); resp.Err != ErrorUnsupportedLanguage.Error() {
+		t.Errorf("create in disabled language: error %q", resp.Err)
+	}
+}
@@ -13,24 +13,36 @@ import (
 
 // List - list all items
 func (s *Service) List(ctx context.Context, req *api.ListRequest) (*api.ListResults, error) {
+	s.langMu.RLock()
+	defer s.langMu.RUnlock()
+
 	var resp = api.ListResults{Type: req.Type, Request: req}
 	var searchRequest *bleve.SearchRequest
 
-	if _, ok := Index[req.Type]; !ok {
+	if !s.hasType(req.Type) {
 		resp.Err = api.ErrorInvalidContentType.Error()
 		return &resp, nil
 	}
 
+	if req.SortBy == "" {
+		req.SortBy = "id"
+	}
+
+	chain := s.fallbackChain(req.Language)
+	key := s.cache.key(ctx, "list", req.Type, req, chain...)
+	if r, ok := s.cache.get(key); ok {
+		cached := *r.(*api.ListResults)
+		cached.Request = req
+		return &cached, nil
+	}
+
+	// Public requests only see published items
 	if req.Status == "" {
-		searchRequest = bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+		searchRequest = bleve.NewSearchRequest(publicQuery(ctx, bleve.NewMatchAllQuery()))
 	} else {
-		query := bleve.NewQueryStringQuery("+status:" + req.Status)
-		searchRequest = bleve.NewSearchRequest(query)
+		searchRequest = bleve.NewSearchRequest(publicQuery(ctx, statusQuery(req.Status)))
 	}
 
-	if req.SortBy == "" {
-		req.SortBy = "id"
-	}
 	searchRequest.SortBy([]string{req.SortBy})
 	searchRequest.Fields = []string{"*"}
 	if req.Size >= 0 {
@@ -42,12 +54,7 @@ func (s *Service) List(ctx context.Context, req *api.ListRequest) (*api.ListResu
 	}
 	searchRequest.From = req.Skip
 
-	index, err := getIndex(req.Type, req.Language)
-	if err != nil {
-		resp.Err = api.ErrorNotFound.Error()
-		return &resp, nil
-	}
-	searchResult, err := index.Search(searchRequest)
+	searchResult, err := s.searchChain(ctx, req.Type, chain, searchRequest)
 	if err != nil {
 		resp.Err = api.ErrorNotFound.Error()
 		return &resp, nil
@@ -59,11 +66,14 @@ func (s *Service) List(ctx context.Context, req *api.ListRequest) (*api.ListResu
 		resp.List = append(resp.List, hit.Fields)
 	}
 
+	cached := resp
+	s.cache.set(key, &cached)
+
 	return &resp, nil
 }
 
 // ListEndpoint - creates endpoint for List service
-func ListEndpoint(svc Service) endpoint.Endpoint {
+func ListEndpoint(svc *Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(api.ListRequest)
 		return svc.List(ctx, &req)
@@ -3,7 +3,6 @@ package service
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 
 	"git.urantiatech.com/cloudcms/cloudcms/api"
@@ -14,57 +13,76 @@ import (
 // Read - returns a single item
 func (s *Service) Read(ctx context.Context, req *api.ReadRequest) (*api.Response, error) {
 	var resp = api.Response{Type: req.Type, Language: req.Language}
-	var db *bolt.DB
 
-	if _, ok := Index[req.Type]; !ok {
+	if !s.hasType(req.Type) {
 		resp.Err = api.ErrorInvalidContentType.Error()
 		return &resp, nil
 	}
 
-	key := fmt.Sprintf("%s.%s.%s", req.Language, req.Type, req.Slug)
-
-	r, ok := RespCache.Get(key)
-	if ok {
-		return r.(*api.Response), nil
-	}
-
-	// Open database in read-only mode
-	// It will be created if it doesn't exist.
-	var err error
-	options := bolt.Options{ReadOnly: true}
-	db, err = bolt.Open(DBFile, 0644, &options)
-	if err != nil {
-		resp.Err = api.ErrorNotFound.Error()
-		return &resp, nil
+	chain := s.fallbackChain(req.Language)
+	key := s.cache.key(ctx, "read", req.Type, req, chain...)
+	if r, ok := s.cache.get(key); ok {
+		cached := *r.(*api.Response)
+		return &cached, nil
 	}
-	defer db.Close()
 
-	err = db.View(func(tx *bolt.Tx) error {
-		bb, err := getBucket(tx, req.Type, req.Language)
-		if err != nil {
-			return err
-		}
-		val := bb.Get([]byte(req.Slug))
-		if val == nil {
-			return api.ErrorNotFound
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Missing items are served in the fallback languages
+		for _, l := range chain {
+			content, err := s.readItem(ctx, tx, req.Type, l, req.Slug)
+			if err == api.ErrorNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			resp.Language = l
+			resp.Content = content
+			return nil
 		}
-
-		err = json.Unmarshal(val, &resp.Content)
-		if err != nil {
-			return err
-		}
-		return nil
+		return api.ErrorNotFound
 	})
 	if err != nil {
 		resp.Err = err.Error()
 	}
 
-	// RespCache.Set(key, &api.Response{resp}, cache.DefaultExpiration)
+	// Missing items are cached too, writes invalidate them
+	if err == nil || err == api.ErrorNotFound {
+		cached := resp
+		s.cache.set(key, &cached)
+	}
 	return &resp, nil
 }
 
+// readItem returns an item visible to the request
+func (s *Service) readItem(ctx context.Context, tx *bolt.Tx, contentType, language, slug string) (map[string]interface{}, error) {
+	bb, err := s.bucket(tx, contentType, language)
+	if err != nil {
+		return nil, err
+	}
+	val := bb.Get([]byte(slug))
+	if val == nil {
+		// Renamed items are found by their old slug
+		if current := redirectOf(tx, contentType, language, slug); current != "" {
+			val = bb.Get([]byte(current))
+		}
+	}
+	if val == nil {
+		return nil, api.ErrorNotFound
+	}
+
+	var content map[string]interface{}
+	if err := json.Unmarshal(val, &content); err != nil {
+		return nil, err
+	}
+	if !isVisible(ctx, content) {
+		return nil, api.ErrorNotFound
+	}
+	return content, nil
+}
+
 // ReadEndpoint - creates endpoint for Read service
-func ReadEndpoint(svc Service) endpoint.Endpoint {
+func ReadEndpoint(svc *Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(api.ReadRequest)
 		return svc.Read(ctx, &req)
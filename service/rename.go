@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/gorilla/mux"
+)
+
+// RedirectBucket maps the old slugs of renamed items to their current slug,
+// nested by content type and language
+const RedirectBucket = "_redirects"
+
+// Rename errors
+var (
+	ErrorEmptySlug  = errors.New("Empty Key")
+	ErrorSlugExists = errors.New("Slug already exists")
+)
+
+// RenameRequest moves the item at Slug to NewSlug
+type RenameRequest struct {
+	Type     string `json:"type"`
+	Language string `json:"language"`
+	Slug     string `json:"slug"`
+	NewSlug  string `json:"new_slug"`
+}
+
+// redirectBucket returns the redirects of content type and language, it is
+// created if create is set and nil is returned if it doesn't exist otherwise
+func redirectBucket(tx *bolt.Tx, contentType, language string, create bool) (*bolt.Bucket, error) {
+	names := []string{RedirectBucket, contentType, language}
+	if !create {
+		b := tx.Bucket([]byte(names[0]))
+		for _, name := range names[1:] {
+			if b == nil {
+				return nil, nil
+			}
+			b = b.Bucket([]byte(name))
+		}
+		return b, nil
+	}
+
+	b, err := tx.CreateBucketIfNotExists([]byte(names[0]))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names[1:] {
+		if b, err = b.CreateBucketIfNotExists([]byte(name)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// redirectOf returns the current slug of a renamed item, or an empty string
+func redirectOf(tx *bolt.Tx, contentType, language, slug string) string {
+	b, err := redirectBucket(tx, contentType, language, false)
+	if err != nil || b == nil {
+		return ""
+	}
+	return string(b.Get([]byte(slug)))
+}
+
+// addRedirect points from and all aliases of from to slug
+func addRedirect(tx *bolt.Tx, contentType, language, from, slug string) error {
+	b, err := redirectBucket(tx, contentType, language, true)
+	if err != nil {
+		return err
+	}
+
+	// Keep redirects one hop long
+	var aliases [][]byte
+	err = b.ForEach(func(k, v []byte) error {
+		if string(v) == from {
+			aliases = append(aliases, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range append(aliases, []byte(from)) {
+		if err := b.Put(k, []byte(slug)); err != nil {
+			return err
+		}
+	}
+	// The new slug is no longer an alias
+	return b.Delete([]byte(slug))
+}
+
+// deleteRedirects removes the aliases of slug
+func deleteRedirects(tx *bolt.Tx, contentType, language, slug string) error {
+	b, err := redirectBucket(tx, contentType, language, false)
+	if err != nil || b == nil {
+		return err
+	}
+	var aliases [][]byte
+	err = b.ForEach(func(k, v []byte) error {
+		if string(v) == slug {
+			aliases = append(aliases, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range aliases {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCanonical sets the Content-Location header of an item read by an
+// old slug of a RESTful route, clients may redirect to it
+func writeCanonical(ctx context.Context, w http.ResponseWriter, response interface{}) {
+	r, ok := response.(*api.Response)
+	c := conditionsFromContext(ctx)
+	if !ok || r.Err != "" || c == nil || c.op != OpRead || c.slug == "" {
+		return
+	}
+	content, _ := r.Content.(map[string]interface{})
+	slug, _ := content["slug"].(string)
+	if slug == "" || slug == c.slug || !strings.HasSuffix(c.path, "/"+c.slug) {
+		return
+	}
+	w.Header().Set("Content-Location", strings.TrimSuffix(c.path, c.slug)+slug)
+}
+
+// Rename - moves an item to a new slug, the old slug redirects to it
+func (s *Service) Rename(ctx context.Context, req *RenameRequest) (*api.Response, error) {
+	s.langMu.RLock()
+	defer s.langMu.RUnlock()
+
+	var resp = api.Response{Type: req.Type, Language: req.Language}
+
+	if !s.hasType(req.Type) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	newSlug, err := normalizeSlug(req.NewSlug, req.Language)
+	if err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bb, err := s.bucket(tx, req.Type, req.Language)
+		if err != nil {
+			return err
+		}
+
+		var content map[string]interface{}
+		val := bb.Get([]byte(req.Slug))
+		if val == nil {
+			return api.ErrorNotFound
+		}
+		if err := json.Unmarshal(val, &content); err != nil {
+			return err
+		}
+		if isTrashed(content) {
+			return api.ErrorNotFound
+		}
+		if err := checkVersion(ctx, content, nil); err != nil {
+			resp.Content = content
+			return err
+		}
+		if newSlug == req.Slug {
+			resp.Content = content
+			return nil
+		}
+		if bb.Get([]byte(newSlug)) != nil {
+			return ErrorSlugExists
+		}
+
+		content["slug"] = newSlug
+		content["updated_at"] = time.Now().Unix()
+		nextVersion(content, itemVersion(content))
+
+		j, err := json.Marshal(content)
+		if err != nil {
+			return err
+		}
+		if err := bb.Put([]byte(newSlug), j); err != nil {
+			return err
+		}
+		if err := bb.Delete([]byte(req.Slug)); err != nil {
+			return err
+		}
+		if err := addRedirect(tx, req.Type, req.Language, req.Slug, newSlug); err != nil {
+			return err
+		}
+		if _, err := s.addRevision(ctx, tx, req.Type, req.Language, "rename", content); err != nil {
+			return err
+		}
+		if err := putGroupMember(tx, req.Type, req.Language, content); err != nil {
+			return err
+		}
+
+		resp.Content = content
+
+		// Move the item in a single index batch
+		index, err := s.getIndex(req.Type, req.Language)
+		if err != nil {
+			return err
+		}
+		batch := index.NewBatch()
+		batch.Delete(req.Slug)
+		if err := batch.Index(newSlug, content); err != nil {
+			return err
+		}
+		if err := index.Batch(batch); err != nil {
+			return err
+		}
+		return syncRevision(tx, index, req.Type, req.Language)
+	})
+	if err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+
+	// Drop the cached responses
+	s.cache.invalidate(req.Type, req.Language)
+
+	return &resp, nil
+}
+
+// RenameEndpoint - creates endpoint for Rename service
+func RenameEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RenameRequest)
+		return svc.Rename(ctx, &req)
+	}
+}
+
+// DecodeRenameReq - decodes the incoming request
+func DecodeRenameReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request RenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// DecodeRESTRenameReq - decodes POST /api/{language}/{type}/{slug}/rename
+// The body holds new_slug, the path sets type, language and slug.
+func DecodeRESTRenameReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request RenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	vars := mux.Vars(r)
+	request.Language = vars["language"]
+	request.Type = vars["type"]
+	request.Slug = vars["slug"]
+	return request, nil
+}
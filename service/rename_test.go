@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+)
+
+func TestRenameSlug(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	for _, slug := range []string{"a", "b"} {
+		if resp, _ := s.Create(ctx, &api.CreateRequest{Type: "article", Language: "en", Slug: slug, Content: map[string]interface{}{"title": slug}}, false); resp.Err != "" {
+			t.Fatal(resp.Err)
+		}
+	}
+
+	tests := []struct {
+		slug    string
+		newSlug string
+		want    string
+		err     error
+	}{
+		{"a", "Hello World", "hello-world", nil},
+		{"hello-world", "hello-world", "hello-world", nil},
+		{"hello-world", "B", "", ErrorSlugExists},
+		{"hello-world", "New", "", ErrorReservedSlug},
+		{"hello-world", "  ", "", ErrorEmptySlug},
+		{"hello-world", "Ünïcode", "unicode", nil},
+	}
+	for _, tt := range tests {
+		resp, _ := s.Rename(ctx, &RenameRequest{Type: "article", Language: "en", Slug: tt.slug, NewSlug: tt.newSlug})
+		if tt.err != nil {
+			if resp.Err != tt.err.Error() {
+				t.Errorf("Rename(%q, %q): error %q, want %q", tt.slug, tt.newSlug, resp.Err, tt.err)
+			}
+			continue
+		}
+		if resp.Err != "" {
+			t.Errorf("Rename(%q, %q): error %q", tt.slug, tt.newSlug, resp.Err)
+		} else if slug := responseSlug(resp); slug != tt.want {
+			t.Errorf("Rename(%q, %q) = %q, want %q", tt.slug, tt.newSlug, slug, tt.want)
+		}
+	}
+}
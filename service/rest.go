@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	"github.com/gorilla/mux"
+)
+
+// errorStatus maps response errors to HTTP status codes of RESTful routes
+var errorStatus = map[string]int{
+	api.ErrorNotFound.Error():           http.StatusNotFound,
+	api.ErrorInvalidContentType.Error(): http.StatusNotFound,
+	api.ErrorNullContent.Error():        http.StatusBadRequest,
+	"Invalid content type":              http.StatusNotFound,
+	"Unsupported language":              http.StatusNotFound,
+	"Unsupported Language":              http.StatusNotFound,
+	"Empty Key":                         http.StatusBadRequest,
+	ErrorValidation.Error():             http.StatusUnprocessableEntity,
+	ErrorInvalidStatus.Error():          http.StatusUnprocessableEntity,
+	ErrorInvalidTransition.Error():      http.StatusConflict,
+	ErrorInvalidSchedule.Error():        http.StatusUnprocessableEntity,
+	ErrorNoFile.Error():                 http.StatusBadRequest,
+	ErrorUploadNotFound.Error():         http.StatusNotFound,
+	ErrorUploadOffset.Error():           http.StatusConflict,
+	ErrorUploadTooLarge.Error():         http.StatusRequestEntityTooLarge,
+	ErrorInvalidUpload.Error():          http.StatusBadRequest,
+	ErrorInvalidFileName.Error():        http.StatusBadRequest,
+	ErrorInvalidFileURI.Error():         http.StatusBadRequest,
+	ErrorFileType.Error():               http.StatusUnsupportedMediaType,
+	ErrorFileTooLarge.Error():           http.StatusRequestEntityTooLarge,
+	ErrorPreconditionFailed.Error():     http.StatusPreconditionFailed,
+	ErrorConflict.Error():               http.StatusConflict,
+	ErrorSlugExists.Error():             http.StatusConflict,
+	ErrorReservedSlug.Error():           http.StatusBadRequest,
+	ErrorSlugTooLong.Error():            http.StatusBadRequest,
+	ErrorTranslationExists.Error():      http.StatusConflict,
+	ErrorInvalidLanguage.Error():        http.StatusBadRequest,
+	ErrorLanguageArchived.Error():       http.StatusForbidden,
+	ErrorLastLanguage.Error():           http.StatusConflict,
+	ErrorInvalidTypeName.Error():        http.StatusBadRequest,
+	ErrorTypeExists.Error():             http.StatusConflict,
+	ErrorTypeNotEmpty.Error():           http.StatusConflict,
+}
+
+// EncodeREST encodes the response of RESTful routes with a status code
+// matching the response error
+func EncodeREST(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	e := stringField(response, "Err")
+	if session, ok := response.(*UploadSession); ok {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	}
+	writeCanonical(ctx, w, response)
+	if r, ok := response.(*api.Response); ok && r.Err == "" && r.Language != "" {
+		// Items may be served in a fallback language
+		w.Header().Set("Content-Language", r.Language)
+	}
+
+	status := http.StatusOK
+	if e != "" {
+		if status = errorStatus[e]; status == 0 {
+			status = http.StatusInternalServerError
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if status == http.StatusOK && writeValidators(ctx, w, response) {
+		return nil
+	}
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(response)
+}
+
+// DecodeRESTCreateReq - decodes POST /api/{language}/{type}
+// The body is a create request, the path sets type and language.
+func DecodeRESTCreateReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request api.CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	vars := mux.Vars(r)
+	request.Type = vars["type"]
+	request.Language = vars["language"]
+	return request, nil
+}
+
+// DecodeRESTReadReq - decodes GET /api/{language}/{type}/{slug}
+func DecodeRESTReadReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	request := api.ReadRequest{
+		Type:     vars["type"],
+		Language: vars["language"],
+		Slug:     vars["slug"],
+	}
+	return request, nil
+}
+
+// DecodeRESTUpdateReq - decodes PUT and PATCH /api/{language}/{type}/{slug}
+// The body is an update request, the path sets type, language and slug.
+func DecodeRESTUpdateReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request api.UpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	vars := mux.Vars(r)
+	request.Type = vars["type"]
+	request.Language = vars["language"]
+	request.Slug = vars["slug"]
+	return request, nil
+}
+
+// DecodeRESTDeleteReq - decodes DELETE /api/{language}/{type}/{slug}
+func DecodeRESTDeleteReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	request := api.DeleteRequest{
+		Type:     vars["type"],
+		Language: vars["language"],
+		Slug:     vars["slug"],
+	}
+	return request, nil
+}
+
+// DecodeRESTListReq - decodes GET /api/{language}/{type}?status=&sort=&skip=&size=
+func DecodeRESTListReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	request := api.ListRequest{
+		Type:     vars["type"],
+		Language: vars["language"],
+		Status:   query.Get("status"),
+		SortBy:   query.Get("sort"),
+		Size:     10,
+	}
+
+	var err error
+	if v := query.Get("skip"); v != "" {
+		if request.Skip, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := query.Get("size"); v != "" {
+		if request.Size, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	return request, nil
+}
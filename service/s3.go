@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3PartSize is the part size of uploads of unknown size, the client
+// buffers a part in memory
+const s3PartSize = 16 << 20
+
+// S3Storage stores files in a bucket of an S3 compatible object store
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Storage connects to cfg.Endpoint and creates cfg.Bucket if missing
+func NewS3Storage(cfg StorageConfig) (*S3Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, ErrorInvalidStorage
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &S3Storage{client: client, bucket: cfg.Bucket}, nil
+}
+
+// s3Error maps missing objects to ErrorFileNotFound
+func s3Error(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return ErrorFileNotFound
+	}
+	return err
+}
+
+// Put uploads r to key
+func (st *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	if size < 0 {
+		opts.PartSize = s3PartSize
+	}
+	_, err := st.client.PutObject(ctx, st.bucket, key, r, size, opts)
+	return err
+}
+
+// Get opens the object of key, the returned reader is seekable
+func (st *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := st.client.GetObject(ctx, st.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	// GetObject is lazy, Stat reports missing objects
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, s3Error(err)
+	}
+	return obj, nil
+}
+
+// Delete removes the object of key
+func (st *S3Storage) Delete(ctx context.Context, key string) error {
+	err := st.client.RemoveObject(ctx, st.bucket, key, minio.RemoveObjectOptions{})
+	if err = s3Error(err); err == ErrorFileNotFound {
+		return nil
+	}
+	return err
+}
+
+// Stat returns the metadata of the object of key
+func (st *S3Storage) Stat(ctx context.Context, key string) (*FileInfo, error) {
+	info, err := st.client.StatObject(ctx, st.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return &FileInfo{Key: key, Size: info.Size, ModTime: info.LastModified, ContentType: info.ContentType}, nil
+}
+
+// List returns the objects whose key starts with prefix
+func (st *S3Storage) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	var list []FileInfo
+	opts := minio.ListObjectsOptions{Prefix: prefix, Recursive: true}
+	// Returning early leaves the listing goroutine blocked until ctx is done
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for info := range st.client.ListObjects(ctx, st.bucket, opts) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		if strings.HasSuffix(info.Key, "/") {
+			continue
+		}
+		list = append(list, FileInfo{Key: info.Key, Size: info.Size, ModTime: info.LastModified, ContentType: info.ContentType})
+	}
+	return list, nil
+}
+
+// Copy copies src to dst on the server
+func (st *S3Storage) Copy(ctx context.Context, src, dst string) error {
+	_, err := st.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: st.bucket, Object: dst},
+		minio.CopySrcOptions{Bucket: st.bucket, Object: src})
+	return s3Error(err)
+}
+
+// Move copies src to dst on the server and removes src
+func (st *S3Storage) Move(ctx context.Context, src, dst string) error {
+	if err := st.Copy(ctx, src, dst); err != nil {
+		return err
+	}
+	return st.Delete(ctx, src)
+}
+
+// SignedURL returns a presigned GET URL of key
+func (st *S3Storage) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if _, err := st.Stat(ctx, key); err != nil {
+		return "", err
+	}
+	u, err := st.client.PresignedGetObject(ctx, st.bucket, key, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory stand-in for the S3 API used by S3Storage, it
+// serves a single bucket with path style requests
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+	uploads map[string]map[int][]byte
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{
+		bucket:  bucket,
+		objects: map[string][]byte{},
+		types:   map[string]string{},
+		uploads: map[string]map[int][]byte{},
+	}
+}
+
+// s3Fail writes an S3 error response
+func s3Fail(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+	}
+}
+
+// readBody returns the payload of r, decoding aws-chunked bodies
+func readBody(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return ioutil.ReadAll(r.Body)
+	}
+	var body bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(strings.SplitN(line, ";", 2)[0]), 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return body.Bytes(), nil
+		}
+		if _, err := io.CopyN(&body, br, size); err != nil {
+			return nil, err
+		}
+		br.ReadString('\n')
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if parts[0] != f.bucket {
+		s3Fail(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	query := r.URL.Query()
+	if len(parts) == 1 || parts[1] == "" {
+		switch {
+		case r.Method == http.MethodHead:
+		case r.Method == http.MethodGet && query.Get("list-type") == "2":
+			f.list(w, r, query.Get("prefix"))
+		default:
+			s3Fail(w, r, http.StatusNotImplemented, "NotImplemented")
+		}
+		return
+	}
+
+	key := parts[1]
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = map[int][]byte{}
+		f.types[key] = r.Header.Get("Content-Type")
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", f.bucket, key, id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		upload, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			s3Fail(w, r, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		b, err := readBody(r)
+		if err != nil {
+			s3Fail(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		upload[n] = b
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, n))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		upload, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			s3Fail(w, r, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var numbers []int
+		for n := range upload {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, n := range numbers {
+			data = append(data, upload[n]...)
+		}
+		f.objects[key] = data
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`, f.bucket, key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		src = strings.TrimPrefix(strings.TrimPrefix(src, "/"), f.bucket+"/")
+		data, ok := f.objects[src]
+		if !ok {
+			s3Fail(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.objects[key] = append([]byte(nil), data...)
+		f.types[key] = f.types[src]
+		fmt.Fprintf(w, `<CopyObjectResult><ETag>"etag"</ETag><LastModified>%s</LastModified></CopyObjectResult>`, time.Now().UTC().Format(time.RFC3339))
+	case r.Method == http.MethodPut:
+		b, err := readBody(r)
+		if err != nil {
+			s3Fail(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = b
+		f.types[key] = r.Header.Get("Content-Type")
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			s3Fail(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Content-Type", f.types[key])
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Fail(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// list answers ListObjectsV2, listing "denied/" fails
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request, prefix string) {
+	if strings.HasPrefix(prefix, "denied/") {
+		s3Fail(w, r, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	type object struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []object
+	}{Name: f.bucket, Prefix: prefix}
+	for key, data := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, object{key, time.Now().UTC().Format(time.RFC3339), `"etag"`, len(data)})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// newTestS3Storage connects an S3Storage to a fakeS3
+func newTestS3Storage(t *testing.T) (*S3Storage, *fakeS3) {
+	fake := newFakeS3("drive")
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	st, err := NewS3Storage(StorageConfig{
+		Driver:   "s3",
+		Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+		Region:   "us-east-1",
+		Bucket:   "drive",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st, fake
+}
+
+func TestS3Storage(t *testing.T) {
+	st, fake := newTestS3Storage(t)
+	testStorage(t, st)
+
+	if len(fake.uploads) != 0 {
+		t.Errorf("%d multipart uploads left open", len(fake.uploads))
+	}
+	if _, ok := fake.objects["article/hi/3/b.txt"]; !ok {
+		t.Error("copy of article/en/3/b.txt missing")
+	}
+	if typ := fake.types["article/hi/3/b.txt"]; typ != "text/plain" {
+		t.Errorf("content type of copy is %q", typ)
+	}
+}
+
+func TestS3StorageList(t *testing.T) {
+	st, _ := newTestS3Storage(t)
+	ctx := context.Background()
+	if err := st.Put(ctx, "denied/a.txt", strings.NewReader("a"), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.List(ctx, "denied/"); err == nil {
+		t.Error("List() of a denied prefix succeeded")
+	}
+	list, err := st.List(ctx, "")
+	if err != nil || len(list) != 1 {
+		t.Errorf("List() = %v, %v", list, err)
+	}
+}
+
+func TestNewStorage(t *testing.T) {
+	tests := []struct {
+		cfg StorageConfig
+		err error
+	}{
+		{StorageConfig{}, nil},
+		{StorageConfig{Driver: "local", Root: "files"}, nil},
+		{StorageConfig{Driver: "s3"}, ErrorInvalidStorage},
+		{StorageConfig{Driver: "s3", Endpoint: "localhost:9000"}, ErrorInvalidStorage},
+		{StorageConfig{Driver: "ftp"}, ErrorInvalidStorage},
+	}
+	for _, tt := range tests {
+		if _, err := NewStorage(tt.cfg); err != tt.err {
+			t.Errorf("NewStorage(%+v) error = %v, want %v", tt.cfg, err, tt.err)
+		}
+	}
+}
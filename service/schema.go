@@ -6,30 +6,39 @@ import (
 	"net/http"
 
 	"git.urantiatech.com/cloudcms/cloudcms/api"
-	"git.urantiatech.com/cloudcms/cloudcms/item"
 	"github.com/go-kit/kit/endpoint"
 )
 
-// Schema - explains the schema
+// Schema - explains the schema of the stored content types
 func (s *Service) Schema(ctx context.Context, req *api.SchemaRequest) (*api.SchemaResponse, error) {
 	var resp = api.SchemaResponse{Schema: make(map[string]api.ContentType)}
 
-	for _, l := range Languages {
+	for _, l := range s.languages() {
 		resp.Languages = append(resp.Languages, l.String())
 	}
 
-	for t, v := range item.Fields {
-		contentType := api.ContentType{
-			Fields: v,
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for t := range s.index {
+		// Field definitions are stored with their validation rules
+		var fields []api.Field
+		b, err := json.Marshal(s.fields[t])
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &fields); err != nil {
+			return nil, err
+		}
+		resp.Schema[t] = api.ContentType{
+			Fields: fields,
 		}
-		resp.Schema[t] = contentType
 	}
 
 	return &resp, nil
 }
 
 // SchemaEndpoint - creates endpoint for Schema service
-func SchemaEndpoint(svc Service) endpoint.Endpoint {
+func SchemaEndpoint(svc *Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(api.SchemaRequest)
 		return svc.Schema(ctx, &req)
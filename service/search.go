@@ -13,15 +13,26 @@ import (
 
 // Search - searches for query
 func (s *Service) Search(ctx context.Context, req *api.SearchRequest) (*api.SearchResults, error) {
+	s.langMu.RLock()
+	defer s.langMu.RUnlock()
+
 	var resp = api.SearchResults{Type: req.Type, Request: req}
 	var searchRequest *bleve.SearchRequest
 	var query q.Query
 
-	if _, ok := Index[req.Type]; !ok {
+	if !s.hasType(req.Type) {
 		resp.Err = api.ErrorInvalidContentType.Error()
 		return &resp, nil
 	}
 
+	chain := s.fallbackChain(req.Language)
+	key := s.cache.key(ctx, "search", req.Type, req, chain...)
+	if r, ok := s.cache.get(key); ok {
+		cached := *r.(*api.SearchResults)
+		cached.Request = req
+		return &cached, nil
+	}
+
 	if req.Query == "" {
 		query = bleve.NewMatchAllQuery()
 	} else if req.Fuzzy {
@@ -30,8 +41,8 @@ func (s *Service) Search(ctx context.Context, req *api.SearchRequest) (*api.Sear
 		query = bleve.NewQueryStringQuery(req.Query)
 	}
 
-	// Create a new search request
-	searchRequest = bleve.NewSearchRequest(query)
+	// Create a new search request, public requests only see published items
+	searchRequest = bleve.NewSearchRequest(publicQuery(ctx, query))
 	searchRequest.Fields = []string{"*"}
 	searchRequest.Highlight = bleve.NewHighlight()
 	searchRequest.Size = req.Size
@@ -40,12 +51,7 @@ func (s *Service) Search(ctx context.Context, req *api.SearchRequest) (*api.Sear
 	}
 	searchRequest.From = req.Skip
 
-	index, err := getIndex(req.Type, req.Language)
-	if err != nil {
-		resp.Err = api.ErrorNotFound.Error()
-		return &resp, nil
-	}
-	searchResult, err := index.Search(searchRequest)
+	searchResult, err := s.searchChain(ctx, req.Type, chain, searchRequest)
 	if err != nil {
 		resp.Err = api.ErrorNotFound.Error()
 		return &resp, nil
@@ -58,11 +64,14 @@ func (s *Service) Search(ctx context.Context, req *api.SearchRequest) (*api.Sear
 		resp.Hits = append(resp.Hits, hit.Fields)
 	}
 
+	cached := resp
+	s.cache.set(key, &cached)
+
 	return &resp, nil
 }
 
 // SearchEndpoint - creates endpoint for Search service
-func SearchEndpoint(svc Service) endpoint.Endpoint {
+func SearchEndpoint(svc *Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(api.SearchRequest)
 		return svc.Search(ctx, &req)
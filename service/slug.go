@@ -0,0 +1,231 @@
+package service
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxSlugLength is the maximum length of slugs in bytes, generated slugs
+// are truncated and longer explicit slugs are rejected
+const MaxSlugLength = 80
+
+// Errors of explicit slugs
+var (
+	ErrorReservedSlug = errors.New("Reserved slug")
+	ErrorSlugTooLong  = errors.New("Slug too long")
+)
+
+// reservedSlugs can't be used as generated slugs as they collide with
+// pages and actions of the frontends
+var reservedSlugs = map[string]bool{
+	"new":    true,
+	"edit":   true,
+	"rename": true,
+	"index":  true,
+	"search": true,
+	"list":   true,
+	"trash":  true,
+	"api":    true,
+	"drive":  true,
+	"upload": true,
+}
+
+// transliterations map runes to ASCII, the "" table is used for all
+// languages, the tables of a language take precedence over it
+var transliterations = map[string]map[rune]string{
+	"": {
+		// Latin letters which don't decompose
+		'ß': "ss", 'æ': "ae", 'ø': "o", 'œ': "oe", 'đ': "d", 'ð': "d",
+		'þ': "th", 'ł': "l", 'ı': "i", 'ŋ': "ng", 'ħ': "h",
+
+		// Russian Cyrillic
+		'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e",
+		'ё': "yo", 'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k",
+		'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r",
+		'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+		'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+		'э': "e", 'ю': "yu", 'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye",
+		'ґ': "g", 'ў': "u", 'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c",
+		'ђ': "dj", 'џ': "dz", 'ѓ': "gj", 'ќ': "kj", 'ѕ': "dz",
+
+		// Greek
+		'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z",
+		'η': "i", 'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m",
+		'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s",
+		'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps",
+		'ω': "o",
+	},
+	"de": {'ä': "ae", 'ö': "oe", 'ü': "ue"},
+	"da": {'å': "aa"},
+	"nb": {'å': "aa"},
+	"uk": {'г': "h", 'и': "y", 'й': "i", 'ї': "i"},
+	"bg": {'щ': "sht", 'ъ': "a", 'ь': "y"},
+}
+
+// Devanagari consonants carry an inherent "a" unless followed by a vowel
+// sign or virama
+var (
+	devanagariConsonants = map[rune]string{
+		'क': "k", 'ख': "kh", 'ग': "g", 'घ': "gh", 'ङ': "n",
+		'च': "ch", 'छ': "chh", 'ज': "j", 'झ': "jh", 'ञ': "n",
+		'ट': "t", 'ठ': "th", 'ड': "d", 'ढ': "dh", 'ण': "n",
+		'त': "t", 'थ': "th", 'द': "d", 'ध': "dh", 'न': "n",
+		'प': "p", 'फ': "ph", 'ब': "b", 'भ': "bh", 'म': "m",
+		'य': "y", 'र': "r", 'ल': "l", 'व': "v", 'श': "sh",
+		'ष': "sh", 'स': "s", 'ह': "h", 'ळ': "l",
+	}
+	devanagariVowels = map[rune]string{
+		'अ': "a", 'आ': "aa", 'इ': "i", 'ई': "i", 'उ': "u", 'ऊ': "u",
+		'ऋ': "ri", 'ए': "e", 'ऐ': "ai", 'ओ': "o", 'औ': "au",
+		'ं': "n", 'ँ': "n", 'ः': "h", 'ॐ': "om",
+		'०': "0", '१': "1", '२': "2", '३': "3", '४': "4",
+		'५': "5", '६': "6", '७': "7", '८': "8", '९': "9",
+	}
+	devanagariSigns = map[rune]string{
+		'ा': "a", 'ि': "i", 'ी': "i", 'ु': "u", 'ू': "u", 'ृ': "ri",
+		'े': "e", 'ै': "ai", 'ो': "o", 'ौ': "au", '्': "",
+	}
+)
+
+// baseLanguage returns the language of a tag, e.g. "pt" of "pt-BR"
+func baseLanguage(language string) string {
+	language = strings.ToLower(language)
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	return language
+}
+
+// transliterate replaces the letters of text having a transliteration in
+// language, other letters are kept
+func transliterate(text, language string) string {
+	local := transliterations[baseLanguage(language)]
+	common := transliterations[""]
+
+	var b strings.Builder
+	inherent := false
+	for _, r := range norm.NFC.String(text) {
+		if sign, ok := devanagariSigns[r]; ok && inherent {
+			b.WriteString(sign)
+			inherent = false
+			continue
+		}
+		if r == '़' {
+			continue
+		}
+		consonant, isConsonant := devanagariConsonants[r]
+		// The final inherent vowel of Hindi words is silent
+		if inherent && unicode.Is(unicode.Devanagari, r) {
+			b.WriteByte('a')
+		}
+		inherent = isConsonant
+		if isConsonant {
+			b.WriteString(consonant)
+			continue
+		}
+
+		// Accented letters use the transliteration of their base letter
+		lower := unicode.ToLower(r)
+		base := []rune(norm.NFD.String(string(lower)))[0]
+		if s, ok := local[lower]; ok {
+			b.WriteString(s)
+		} else if s, ok := common[lower]; ok {
+			b.WriteString(s)
+		} else if s, ok := common[base]; ok {
+			b.WriteString(s)
+		} else if s, ok := devanagariVowels[r]; ok {
+			b.WriteString(s)
+		} else {
+			b.WriteRune(lower)
+		}
+	}
+	return b.String()
+}
+
+// slugify converts text to lowercase words joined by hyphens. Accents are
+// removed and letters are transliterated, letters of scripts without a
+// transliteration are kept unless ascii is set.
+func slugify(text, language string, ascii bool) string {
+	return truncateSlug(slugWords(text, language, ascii), MaxSlugLength)
+}
+
+// slugWords is slugify without the length limit
+func slugWords(text, language string, ascii bool) string {
+	// Decompose to drop accents, e.g. "é" becomes "e"
+	text = norm.NFKD.String(transliterate(text, language))
+
+	var b strings.Builder
+	hyphen := false
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case r >= 'A' && r <= 'Z':
+			r += 'a' - 'A'
+		case !ascii && (unicode.IsLetter(r) || unicode.IsNumber(r)):
+			r = unicode.ToLower(r)
+		default:
+			hyphen = b.Len() > 0
+			continue
+		}
+		if hyphen {
+			b.WriteByte('-')
+			hyphen = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// truncateSlug shortens slug to at most max bytes, preferably at a word
+// boundary
+func truncateSlug(slug string, max int) string {
+	if len(slug) <= max {
+		return slug
+	}
+	cut := max
+	for cut > 0 && slug[cut]&0xc0 == 0x80 {
+		cut--
+	}
+	if i := strings.LastIndexByte(slug[:cut], '-'); i > max/2 {
+		cut = i
+	}
+	return strings.Trim(slug[:cut], "-")
+}
+
+// stringToSlug generates the slug of a title in language, it is never
+// empty and never a reserved word
+func stringToSlug(title, language string) string {
+	slug := slugify(title, language, false)
+	if slug == "" {
+		// Titles without letters or digits, e.g. only emoji
+		sum := sha1.Sum([]byte(title))
+		return "item-" + hex.EncodeToString(sum[:4])
+	}
+	if reservedSlugs[slug] {
+		slug += "-1"
+	}
+	return slug
+}
+
+// normalizeSlug converts a slug given by a request to its canonical form.
+// Unlike generated slugs, reserved words aren't suffixed and long slugs
+// aren't truncated, they are rejected.
+func normalizeSlug(slug, language string) (string, error) {
+	slug = slugWords(slug, language, false)
+	switch {
+	case slug == "":
+		return "", ErrorEmptySlug
+	case len(slug) > MaxSlugLength:
+		return "", ErrorSlugTooLong
+	case reservedSlugs[slug]:
+		return "", ErrorReservedSlug
+	}
+	return slug, nil
+}
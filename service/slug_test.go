@@ -0,0 +1,117 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		text, language string
+		ascii          bool
+		slug           string
+	}{
+		{"Hello World", "en", false, "hello-world"},
+		{"  --Hello,   World!--  ", "en", false, "hello-world"},
+		{"Crème Brûlée", "fr", false, "creme-brulee"},
+		{"Straße", "de", false, "strasse"},
+		{"Über", "de", false, "ueber"},
+		{"Über", "en", false, "uber"},
+		{"Smörgåsbord", "da", false, "smorgaasbord"},
+		{"Привет мир", "ru", false, "privet-mir"},
+		{"Київ", "uk", false, "kyiv"},
+		{"Ελλάδα", "el", false, "ellada"},
+		{"नमस्ते", "hi", false, "namaste"},
+		{"日本語 2024", "ja", false, "日本語-2024"},
+		{"日本語 2024", "ja", true, "2024"},
+		{"Über", "de-AT", false, "ueber"},
+		{"🎉", "en", false, ""},
+	}
+	for _, tt := range tests {
+		if slug := slugify(tt.text, tt.language, tt.ascii); slug != tt.slug {
+			t.Errorf("slugify(%q, %q, %v) = %q, want %q", tt.text, tt.language, tt.ascii, slug, tt.slug)
+		}
+	}
+}
+
+func TestStringToSlug(t *testing.T) {
+	long := strings.Repeat("word ", 30)
+	tests := []struct {
+		title, language string
+		slug            string
+	}{
+		{"Hello World", "en", "hello-world"},
+		{"New", "en", "new-1"},
+		{"edit", "en", "edit-1"},
+		{"Search!", "en", "search-1"},
+		{"Drive", "en", "drive-1"},
+		{"Newsletter", "en", "newsletter"},
+		{"new edit", "en", "new-edit"},
+		{long, "en", strings.TrimSuffix(strings.Repeat("word-", 16), "-")},
+	}
+	for _, tt := range tests {
+		if slug := stringToSlug(tt.title, tt.language); slug != tt.slug {
+			t.Errorf("stringToSlug(%q, %q) = %q, want %q", tt.title, tt.language, slug, tt.slug)
+		}
+	}
+
+	// Titles without letters or digits get a stable generated slug
+	slug := stringToSlug("🎉🎉", "en")
+	if !strings.HasPrefix(slug, "item-") || len(slug) != len("item-")+8 {
+		t.Errorf("stringToSlug(emoji) = %q", slug)
+	}
+	if other := stringToSlug("🎉🎉", "en"); other != slug {
+		t.Errorf("stringToSlug(emoji) = %q, then %q", slug, other)
+	}
+	if other := stringToSlug("🎈", "en"); other == slug {
+		t.Errorf("stringToSlug() = %q for different titles", other)
+	}
+}
+
+func TestTruncateSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"hello-world-again", 14, "hello-world"},
+		{"helloworldagain", 10, "helloworld"},
+		{"a-verylongword", 10, "a-verylong"},
+		{"日本語", 4, "日"},
+	}
+	for _, tt := range tests {
+		if got := truncateSlug(tt.slug, tt.max); got != tt.want {
+			t.Errorf("truncateSlug(%q, %d) = %q, want %q", tt.slug, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeSlug(t *testing.T) {
+	long := strings.Repeat("a", MaxSlugLength)
+	tests := []struct {
+		slug, language string
+		want           string
+		err            error
+	}{
+		{"hello-world", "en", "hello-world", nil},
+		{"Hello World", "en", "hello-world", nil},
+		{"über", "de", "ueber", nil},
+		{"नमस्ते", "hi", "namaste", nil},
+		{long, "en", long, nil},
+		{long + "b", "en", "", ErrorSlugTooLong},
+		{strings.Repeat("щ", MaxSlugLength/4+1), "ru", "", ErrorSlugTooLong},
+		{"new", "en", "", ErrorReservedSlug},
+		{"Search!", "en", "", ErrorReservedSlug},
+		{"new-1", "en", "new-1", nil},
+		{"newsletter", "en", "newsletter", nil},
+		{"", "en", "", ErrorEmptySlug},
+		{"🎉", "en", "", ErrorEmptySlug},
+	}
+	for _, tt := range tests {
+		slug, err := normalizeSlug(tt.slug, tt.language)
+		if slug != tt.want || err != tt.err {
+			t.Errorf("normalizeSlug(%q, %q) = %q, %v, want %q, %v", tt.slug, tt.language, slug, err, tt.want, tt.err)
+		}
+	}
+}
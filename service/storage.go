@@ -0,0 +1,394 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DrivePrefix is the URI prefix of stored files
+const DrivePrefix = "/drive/"
+
+// Storage errors
+var (
+	ErrorFileNotFound   = errors.New("File not found")
+	ErrorInvalidStorage = errors.New("Invalid storage driver")
+	ErrorInvalidFileKey = errors.New("Invalid file key")
+)
+
+// FileInfo is the metadata of a stored file
+type FileInfo struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	ContentType string    `json:"content_type,omitempty"`
+}
+
+// Storage stores the uploaded files. Keys are slash separated paths such as
+// "article/en/1/cover.jpg", the file is served at DrivePrefix + key.
+type Storage interface {
+	// Put stores r under key, size is -1 if unknown
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Get opens the file stored under key, ErrorFileNotFound is returned
+	// if it doesn't exist
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete removes the file stored under key, missing files are ignored
+	Delete(ctx context.Context, key string) error
+
+	// Stat returns the metadata of the file stored under key
+	Stat(ctx context.Context, key string) (*FileInfo, error)
+
+	// List returns all files whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]FileInfo, error)
+
+	// SignedURL returns an URL granting read access to key until expiry
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// Mover is implemented by storages able to rename a file without copying it
+type Mover interface {
+	Move(ctx context.Context, src, dst string) error
+}
+
+// Copier is implemented by storages able to copy a file without streaming
+// it through the service
+type Copier interface {
+	Copy(ctx context.Context, src, dst string) error
+}
+
+// StorageConfig selects and configures the storage of uploaded files
+type StorageConfig struct {
+	// Driver is "local" (default) or "s3"
+	Driver string
+
+	// Root is the directory of the local driver, "drive" by default
+	Root string
+
+	// S3 compatible object store
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// NewStorage creates the storage selected by cfg.Driver
+func NewStorage(cfg StorageConfig) (Storage, error) {
+	switch cfg.Driver {
+	case "", "local":
+		root := cfg.Root
+		if root == "" {
+			root = "drive"
+		}
+		return &LocalStorage{Root: root}, nil
+	case "s3":
+		return NewS3Storage(cfg)
+	}
+	return nil, ErrorInvalidStorage
+}
+
+// fileURI returns the URI serving the file stored under key
+func fileURI(key string) string {
+	return DrivePrefix + key
+}
+
+// fileKey returns the storage key of a file URI
+func fileKey(uri string) (string, bool) {
+	if !strings.HasPrefix(uri, DrivePrefix) {
+		return "", false
+	}
+	key := strings.TrimPrefix(path.Clean(uri), DrivePrefix)
+	return key, key != "" && !strings.HasPrefix(key, "/")
+}
+
+// moveFile renames src to dst, copying the file if the storage isn't a Mover
+func moveFile(ctx context.Context, st Storage, src, dst string) error {
+	if m, ok := st.(Mover); ok {
+		return m.Move(ctx, src, dst)
+	}
+	r, err := st.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	info, err := st.Stat(ctx, src)
+	if err != nil {
+		return err
+	}
+	if err := st.Put(ctx, dst, r, info.Size, info.ContentType); err != nil {
+		return err
+	}
+	return st.Delete(ctx, src)
+}
+
+// copyFile copies src to dst, streaming the file if the storage isn't a Copier
+func copyFile(ctx context.Context, st Storage, src, dst string) error {
+	if c, ok := st.(Copier); ok {
+		return c.Copy(ctx, src, dst)
+	}
+	info, err := st.Stat(ctx, src)
+	if err != nil {
+		return err
+	}
+	r, err := st.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return st.Put(ctx, dst, r, info.Size, info.ContentType)
+}
+
+// deleteFiles removes all files whose key starts with prefix
+func deleteFiles(ctx context.Context, st Storage, prefix string) error {
+	list, err := st.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, f := range list {
+		if err := st.Delete(ctx, f.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LocalStorage stores files in a directory of the local filesystem
+type LocalStorage struct {
+	Root string
+}
+
+// path returns the filesystem path of key, it can't escape Root
+func (l *LocalStorage) path(key string) (string, error) {
+	key = path.Clean("/" + key)
+	if key == "/" {
+		return "", ErrorInvalidFileKey
+	}
+	return filepath.Join(l.Root, filepath.FromSlash(key)), nil
+}
+
+// Put writes r to a temporary file renamed to key once complete
+func (l *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(name)+"-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Get opens the file of key
+func (l *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, ErrorFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err != nil || fi.IsDir() {
+		f.Close()
+		return nil, ErrorFileNotFound
+	}
+	return f, nil
+}
+
+// Delete removes the file of key and the directories left empty
+func (l *LocalStorage) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	root := filepath.Clean(l.Root)
+	for dir := filepath.Dir(name); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Stat returns the metadata of the file of key
+func (l *LocalStorage) Stat(ctx context.Context, key string) (*FileInfo, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(name)
+	if os.IsNotExist(err) || (err == nil && fi.IsDir()) {
+		return nil, ErrorFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &FileInfo{Key: path.Clean("/" + key)[1:], Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// List walks the directories below prefix
+func (l *LocalStorage) List(ctx context.Context, prefix string) ([]FileInfo, error) {
+	var list []FileInfo
+	root := filepath.Clean(l.Root)
+	dir := root
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		var err error
+		if dir, err = l.path(prefix[:i]); err != nil {
+			return nil, err
+		}
+	}
+
+	err := filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// Skip the temporary files of Put
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			list = append(list, FileInfo{Key: key, Size: fi.Size(), ModTime: fi.ModTime()})
+		}
+		return nil
+	})
+	return list, err
+}
+
+// Move renames the file of src
+func (l *LocalStorage) Move(ctx context.Context, src, dst string) error {
+	from, err := l.path(src)
+	if err != nil {
+		return err
+	}
+	to, err := l.path(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(from); os.IsNotExist(err) {
+		return ErrorFileNotFound
+	}
+	if err := os.MkdirAll(filepath.Dir(to), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	return l.Delete(ctx, src)
+}
+
+// SignedURL returns the public URI of key, local files are served by
+// DriveHandler to everyone so the URL is not signed
+func (l *LocalStorage) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if _, err := l.Stat(ctx, key); err != nil {
+		return "", err
+	}
+	return fileURI(key), nil
+}
+
+// DriveHandler serves the stored files and their image derivatives
+// selected by the w, h, fit and format query parameters. The DrivePrefix
+// must be stripped from the request path.
+func DriveHandler(st Storage, images ImageConfig) http.Handler {
+	if images.CacheDir == "" {
+		images.CacheDir = "cache/images"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		key := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+
+		d, err := images.parseDerivative(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if d != nil {
+			images.serveDerivative(w, r, st, key, d)
+			return
+		}
+		serveFile(w, r, st, key)
+	})
+}
+
+// serveFile writes the file stored under key, ranges and conditional
+// requests are supported if the storage returns seekable files
+func serveFile(w http.ResponseWriter, r *http.Request, st Storage, key string) {
+	info, err := st.Stat(r.Context(), key)
+	if err == ErrorFileNotFound || err == ErrorInvalidFileKey {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f, err := st.Get(r.Context(), key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(key), info.ModTime, rs)
+		return
+	}
+	if info.ContentType == "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, f)
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// failingReader returns err after the bytes of data
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// storageKeys returns the sorted keys of list
+func storageKeys(list []FileInfo) []string {
+	keys := []string{}
+	for _, f := range list {
+		keys = append(keys, f.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// testStorage runs the operations every storage supports against st
+func testStorage(t *testing.T, st Storage) {
+	ctx := context.Background()
+	for _, key := range []string{"article/en/1/a.txt", "article/en/1/b.txt", "article/en/2/a.txt", "article/hi/1/a.txt", "page/en/1/a.txt"} {
+		if err := st.Put(ctx, key, strings.NewReader("data of "+key), -1, "text/plain"); err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+	if err := st.Put(ctx, "article/en/1/c.txt", strings.NewReader("sized"), 5, "text/plain"); err != nil {
+		t.Fatalf("Put(sized): %v", err)
+	}
+
+	r, err := st.Get(ctx, "article/en/1/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(b) != "data of article/en/1/a.txt" {
+		t.Errorf("Get() = %q, %v", b, err)
+	}
+	if _, err := st.Get(ctx, "article/en/1/missing.txt"); err != ErrorFileNotFound {
+		t.Errorf("Get(missing) error = %v, want %v", err, ErrorFileNotFound)
+	}
+
+	info, err := st.Stat(ctx, "article/en/1/c.txt")
+	if err != nil || info.Key != "article/en/1/c.txt" || info.Size != 5 || info.ModTime.IsZero() {
+		t.Errorf("Stat() = %+v, %v", info, err)
+	}
+	if _, err := st.Stat(ctx, "article/en/1/missing.txt"); err != ErrorFileNotFound {
+		t.Errorf("Stat(missing) error = %v, want %v", err, ErrorFileNotFound)
+	}
+
+	tests := []struct {
+		prefix string
+		keys   []string
+	}{
+		{"article/en/1/", []string{"article/en/1/a.txt", "article/en/1/b.txt", "article/en/1/c.txt"}},
+		{"article/en/", []string{"article/en/1/a.txt", "article/en/1/b.txt", "article/en/1/c.txt", "article/en/2/a.txt"}},
+		{"article/", []string{"article/en/1/a.txt", "article/en/1/b.txt", "article/en/1/c.txt", "article/en/2/a.txt", "article/hi/1/a.txt"}},
+		{"article/en/1/b", []string{"article/en/1/b.txt"}},
+		{"article/fr/", []string{}},
+	}
+	for _, tt := range tests {
+		list, err := st.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%s): %v", tt.prefix, err)
+		}
+		if keys := storageKeys(list); !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("List(%s) = %v, want %v", tt.prefix, keys, tt.keys)
+		}
+	}
+
+	if err := moveFile(ctx, st, "article/en/1/b.txt", "article/en/3/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyFile(ctx, st, "article/en/3/b.txt", "article/hi/3/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	for key, exists := range map[string]bool{"article/en/1/b.txt": false, "article/en/3/b.txt": true, "article/hi/3/b.txt": true} {
+		if _, err := st.Stat(ctx, key); (err == nil) != exists {
+			t.Errorf("Stat(%s) error = %v after move and copy", key, err)
+		}
+	}
+
+	if err := st.Delete(ctx, "article/en/1/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Delete(ctx, "article/en/1/a.txt"); err != nil {
+		t.Errorf("Delete(missing) error = %v", err)
+	}
+	if _, err := st.Get(ctx, "article/en/1/a.txt"); err != ErrorFileNotFound {
+		t.Errorf("Get(deleted) error = %v, want %v", err, ErrorFileNotFound)
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	testStorage(t, &LocalStorage{Root: t.TempDir()})
+}
+
+func TestLocalStoragePut(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	st := &LocalStorage{Root: root}
+	if err := st.Put(ctx, "a/b.txt", strings.NewReader("old"), -1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed write leaves neither the partial file nor a temporary file
+	broken := errors.New("broken")
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{"new file", "a/c.txt", ""},
+		{"existing file", "a/b.txt", "old"},
+	}
+	for _, tt := range tests {
+		if err := st.Put(ctx, tt.key, &failingReader{"partial", broken}, -1, ""); err != broken {
+			t.Errorf("%s: Put() error = %v, want %v", tt.name, err, broken)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(tt.key)))
+		if tt.want == "" && !os.IsNotExist(err) {
+			t.Errorf("%s: partial file %q kept", tt.name, b)
+		}
+		if tt.want != "" && string(b) != tt.want {
+			t.Errorf("%s: file %q, want %q", tt.name, b, tt.want)
+		}
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(root, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "b.txt" {
+		for _, e := range entries {
+			t.Errorf("unexpected file %s", e.Name())
+		}
+	}
+}
+
+func TestLocalStoragePath(t *testing.T) {
+	root := t.TempDir()
+	st := &LocalStorage{Root: root}
+	tests := []struct {
+		key  string
+		path string
+		err  error
+	}{
+		{"a/b.txt", filepath.Join(root, "a", "b.txt"), nil},
+		{"/a/b.txt", filepath.Join(root, "a", "b.txt"), nil},
+		{"../../etc/passwd", filepath.Join(root, "etc", "passwd"), nil},
+		{"a/../../b.txt", filepath.Join(root, "b.txt"), nil},
+		{"", "", ErrorInvalidFileKey},
+		{"..", "", ErrorInvalidFileKey},
+	}
+	for _, tt := range tests {
+		p, err := st.path(tt.key)
+		if p != tt.path || err != tt.err {
+			t.Errorf("path(%q) = %q, %v, want %q, %v", tt.key, p, err, tt.path, tt.err)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/gorilla/mux"
+)
+
+// ErrorTranslationExists is returned when the target language already has
+// a translation of the item
+var ErrorTranslationExists = errors.New("Translation already exists")
+
+// TranslationsRequest identifies an item whose translations are returned
+type TranslationsRequest struct {
+	Type     string `json:"type"`
+	Language string `json:"language"`
+	Slug     string `json:"slug"`
+}
+
+// TranslationsResponse holds the translations of an item by language, and
+// the enabled languages it isn't translated to
+type TranslationsResponse struct {
+	Type         string                 `json:"type"`
+	Group        string                 `json:"translation_group"`
+	Translations map[string]interface{} `json:"translations"`
+	Missing      []string               `json:"missing,omitempty"`
+	Err          string                 `json:"error,omitempty"`
+}
+
+// TranslateRequest creates the translation of an item in Target language.
+// The translation is seeded from the source item, Content overrides its
+// fields. It keeps the slug of the source item unless Slug or SlugText is
+// provided.
+type TranslateRequest struct {
+	Type     string                 `json:"type"`
+	Language string                 `json:"language"`
+	Slug     string                 `json:"slug"`
+	Target   string                 `json:"target"`
+	NewSlug  string                 `json:"new_slug"`
+	SlugText string                 `json:"slugtext"`
+	Content  map[string]interface{} `json:"content"`
+}
+
+// MissingTranslationsRequest reports the items of a content type missing a
+// translation, only in Language if provided
+type MissingTranslationsRequest struct {
+	Type     string `json:"type"`
+	Language string `json:"language"`
+}
+
+// TranslationRef identifies an existing item of a translation group
+type TranslationRef struct {
+	Group    string `json:"translation_group"`
+	Language string `json:"language"`
+	Slug     string `json:"slug"`
+}
+
+// MissingTranslationsResponse lists the items missing by language
+type MissingTranslationsResponse struct {
+	Type    string                      `json:"type"`
+	Missing map[string][]TranslationRef `json:"missing"`
+	Total   int                         `json:"total"`
+	Err     string                      `json:"error,omitempty"`
+}
+
+// translationGroup returns the group of an item, items created before
+// translation groups are in a group of their own
+func translationGroup(content map[string]interface{}) string {
+	if group, _ := content["translation_group"].(string); group != "" {
+		return group
+	}
+	language, _ := content["language"].(string)
+	id, _ := toInt64(content["id"])
+	return fmt.Sprintf("%s-%d", language, id)
+}
+
+// findTranslations returns the items of group by enabled language, trashed
+// items are not members of a group
+func (s *Service) findTranslations(tx *bolt.Tx, contentType, group string) (map[string]map[string]interface{}, error) {
+	members, err := readGroup(tx, contentType, group)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]map[string]interface{})
+	for _, l := range s.languages() {
+		m, ok := members[l.String()]
+		if !ok {
+			continue
+		}
+		bb, err := s.bucket(tx, contentType, l.String())
+		if err != nil {
+			return nil, err
+		}
+		val := bb.Get([]byte(m.Slug))
+		if val == nil {
+			continue
+		}
+		var content map[string]interface{}
+		if err := json.Unmarshal(val, &content); err != nil {
+			return nil, err
+		}
+		found[l.String()] = content
+	}
+	return found, nil
+}
+
+// Translations - returns all translations of an item
+func (s *Service) Translations(ctx context.Context, req *TranslationsRequest) (*TranslationsResponse, error) {
+	var resp = TranslationsResponse{Type: req.Type, Translations: make(map[string]interface{})}
+
+	if !s.hasType(req.Type) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bb, err := s.bucket(tx, req.Type, req.Language)
+		if err != nil {
+			return err
+		}
+		var content map[string]interface{}
+		val := bb.Get([]byte(req.Slug))
+		if val == nil {
+			return api.ErrorNotFound
+		}
+		if err := json.Unmarshal(val, &content); err != nil {
+			return err
+		}
+		if isTrashed(content) {
+			return api.ErrorNotFound
+		}
+		resp.Group = translationGroup(content)
+
+		found, err := s.findTranslations(tx, req.Type, resp.Group)
+		if err != nil {
+			return err
+		}
+		for _, l := range s.languages() {
+			item, ok := found[l.String()]
+			if !ok {
+				resp.Missing = append(resp.Missing, l.String())
+				continue
+			}
+			// Public requests only see published translations
+			if status, _ := item["status"].(string); status != StatusPublished && isPublic(ctx) {
+				continue
+			}
+			resp.Translations[l.String()] = item
+		}
+		if _, ok := resp.Translations[req.Language]; !ok {
+			return api.ErrorNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		resp.Err = err.Error()
+	}
+	return &resp, nil
+}
+
+// seedTranslation copies the content of a source item without the fields
+// maintained by the service. Files are copied in storage to the staging
+// directory of language, the translation adopts them.
+func (s *Service) seedTranslation(ctx context.Context, source map[string]interface{}, contentType, language string) (map[string]interface{}, error) {
+	seed := make(map[string]interface{})
+	for k, v := range source {
+		if systemFields[k] {
+			continue
+		}
+		filemap, ok := v.(map[string]interface{})
+		if !strings.HasPrefix(k, "file:") || !ok {
+			seed[k] = v
+			continue
+		}
+
+		uri, _ := filemap["uri"].(string)
+		key, ok := fileKey(uri)
+		if !ok {
+			continue
+		}
+		token, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+		dst := stagingDir(contentType, language) + "/" + token + "/" + path.Base(key)
+		if err := copyFile(ctx, s.Storage, key, dst); err != nil {
+			return nil, err
+		}
+		name, _ := filemap["name"].(string)
+		seed[k] = map[string]interface{}{"name": name, "uri": fileURI(dst)}
+	}
+	return seed, nil
+}
+
+// Translate - creates a translation of an item seeded from it
+func (s *Service) Translate(ctx context.Context, req *TranslateRequest) (*api.Response, error) {
+	var resp = api.Response{Type: req.Type, Language: req.Target}
+	var source map[string]interface{}
+	var group string
+
+	if !s.hasType(req.Type) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bb, err := s.bucket(tx, req.Type, req.Language)
+		if err != nil {
+			return err
+		}
+		if _, err := s.bucket(tx, req.Type, req.Target); err != nil {
+			return err
+		}
+		val := bb.Get([]byte(req.Slug))
+		if val == nil {
+			return api.ErrorNotFound
+		}
+		if err := json.Unmarshal(val, &source); err != nil {
+			return err
+		}
+		if isTrashed(source) {
+			return api.ErrorNotFound
+		}
+		group = translationGroup(source)
+
+		// Create checks it again, files aren't copied in vain
+		members, err := readGroup(tx, req.Type, group)
+		if err != nil {
+			return err
+		}
+		if _, ok := members[req.Target]; ok {
+			return ErrorTranslationExists
+		}
+		return nil
+	})
+	if err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+
+	seed, err := s.seedTranslation(ctx, source, req.Type, req.Target)
+	if err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+	for k, v := range req.Content {
+		seed[k] = v
+	}
+	seed["translation_group"] = group
+
+	// Create fails if the group has an item in Target already
+	var opts createOptions
+	create := api.CreateRequest{Type: req.Type, Language: req.Target, Content: seed}
+	if req.NewSlug != "" {
+		create.Slug = req.NewSlug
+	} else if req.SlugText != "" {
+		create.SlugText = req.SlugText
+		opts.uniqueSlug = true
+	} else {
+		// The slug of the source item is suffixed if taken in Target
+		create.Slug = req.Slug
+		opts.uniqueSlug = true
+	}
+	return s.create(ctx, &create, opts)
+}
+
+// MissingTranslations - reports the items missing a translation in each
+// enabled language
+func (s *Service) MissingTranslations(ctx context.Context, req *MissingTranslationsRequest) (*MissingTranslationsResponse, error) {
+	var resp = MissingTranslationsResponse{Type: req.Type, Missing: make(map[string][]TranslationRef)}
+
+	if !s.hasType(req.Type) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	// The existing items of each group by language
+	groups := make(map[string]map[string]groupMember)
+	var names []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := typeGroups(tx, req.Type)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			members, err := decodeGroup(v)
+			if err != nil {
+				return err
+			}
+			groups[string(k)] = members
+			names = append(names, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+
+	for _, l := range s.languages() {
+		if req.Language != "" && req.Language != l.String() {
+			continue
+		}
+		for _, group := range names {
+			members := groups[group]
+			if _, ok := members[l.String()]; ok {
+				continue
+			}
+			// Refer to the item in the first language having one
+			for _, source := range s.languages() {
+				if m, ok := members[source.String()]; ok {
+					ref := TranslationRef{Group: group, Language: source.String(), Slug: m.Slug}
+					resp.Missing[l.String()] = append(resp.Missing[l.String()], ref)
+					resp.Total++
+					break
+				}
+			}
+		}
+	}
+	return &resp, nil
+}
+
+// TranslationsEndpoint - creates endpoint for Translations service
+func TranslationsEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(TranslationsRequest)
+		return svc.Translations(ctx, &req)
+	}
+}
+
+// TranslateEndpoint - creates endpoint for Translate service
+func TranslateEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(TranslateRequest)
+		return svc.Translate(ctx, &req)
+	}
+}
+
+// MissingTranslationsEndpoint - creates endpoint for MissingTranslations service
+func MissingTranslationsEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(MissingTranslationsRequest)
+		return svc.MissingTranslations(ctx, &req)
+	}
+}
+
+// DecodeTranslationsReq - decodes the incoming request
+func DecodeTranslationsReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request TranslationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// DecodeTranslateReq - decodes the incoming request
+func DecodeTranslateReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request TranslateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// DecodeMissingTranslationsReq - decodes the incoming request
+func DecodeMissingTranslationsReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request MissingTranslationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// DecodeRESTTranslationsReq - decodes GET /api/{language}/{type}/{slug}/translations
+func DecodeRESTTranslationsReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	request := TranslationsRequest{
+		Type:     vars["type"],
+		Language: vars["language"],
+		Slug:     vars["slug"],
+	}
+	return request, nil
+}
+
+// DecodeRESTTranslateReq - decodes POST /api/{language}/{type}/{slug}/translations
+// The body holds the target language and the fields to override.
+func DecodeRESTTranslateReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request TranslateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	vars := mux.Vars(r)
+	request.Type = vars["type"]
+	request.Language = vars["language"]
+	request.Slug = vars["slug"]
+	return request, nil
+}
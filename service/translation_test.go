@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	"golang.org/x/text/language"
+)
+
+// newTestService opens a service storing an "article" content type in
+// English and Hindi in a temporary directory
+func newTestService(t *testing.T) *Service {
+	dir := t.TempDir()
+	s := &Service{
+		DBFile:    filepath.Join(dir, "db"),
+		Languages: []language.Tag{language.English, language.Hindi},
+		UploadDir: filepath.Join(dir, "uploads"),
+		Storage:   &LocalStorage{Root: filepath.Join(dir, "drive")},
+	}
+	if err := s.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	resp, _ := s.CreateType(context.Background(), &TypeDefinition{Name: "article"})
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	return s
+}
+
+// responseSlug returns the slug of the item in resp
+func responseSlug(resp *api.Response) string {
+	content, _ := resp.Content.(map[string]interface{})
+	slug, _ := content["slug"].(string)
+	return slug
+}
+
+func TestCreateSlugCollisions(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		language string
+		slug     string
+		slugText string
+		group    string
+		want     string
+		err      error
+	}{
+		{"explicit slug", "en", "hello", "", "", "hello", nil},
+		{"explicit slug taken", "en", "hello", "", "", "", ErrorSlugExists},
+		{"generated slug suffixed", "en", "", "Hello", "", "hello-2", nil},
+		{"generated slug suffixed again", "en", "", "Hello", "", "hello-3", nil},
+		{"slug free in other language", "hi", "hello", "", "", "hello", nil},
+		{"reserved word", "en", "", "New", "", "new-1", nil},
+		{"suffix of reserved word", "en", "", "New", "", "new-1-2", nil},
+		{"explicit slug normalized", "en", "Hello World!", "", "", "hello-world", nil},
+		{"explicit slug normalized taken", "en", "HELLO", "", "", "", ErrorSlugExists},
+		{"explicit reserved word", "en", "Edit", "", "", "", ErrorReservedSlug},
+		{"explicit slug without letters", "en", "--", "", "", "", ErrorEmptySlug},
+		{"group has language", "en", "other", "", "en-1", "", ErrorTranslationExists},
+		{"group lacks language", "hi", "namaste", "", "en-1", "namaste", nil},
+		{"group has language now", "hi", "namaste-2", "", "en-1", "", ErrorTranslationExists},
+	}
+	for _, tt := range tests {
+		content := map[string]interface{}{"title": tt.name}
+		if tt.group != "" {
+			content["translation_group"] = tt.group
+		}
+		resp, err := s.Create(ctx, &api.CreateRequest{Type: "article", Language: tt.language, Slug: tt.slug, SlugText: tt.slugText, Content: content}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.err != nil {
+			if resp.Err != tt.err.Error() {
+				t.Errorf("%s: error %q, want %q", tt.name, resp.Err, tt.err)
+			}
+			continue
+		}
+		if resp.Err != "" {
+			t.Errorf("%s: error %q", tt.name, resp.Err)
+		} else if slug := responseSlug(resp); slug != tt.want {
+			t.Errorf("%s: slug %q, want %q", tt.name, slug, tt.want)
+		}
+	}
+}
+
+func TestTranslateCollisions(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	for _, item := range []struct{ language, slug string }{{"en", "hello"}, {"en", "world"}, {"en", "moon"}, {"hi", "hello"}} {
+		resp, _ := s.Create(ctx, &api.CreateRequest{Type: "article", Language: item.language, Slug: item.slug, Content: map[string]interface{}{"title": item.slug}}, false)
+		if resp.Err != "" {
+			t.Fatal(resp.Err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		req  TranslateRequest
+		want string
+		err  error
+	}{
+		{"source slug taken in target", TranslateRequest{Slug: "hello"}, "hello-2", nil},
+		{"translation exists", TranslateRequest{Slug: "hello"}, "", ErrorTranslationExists},
+		{"translation exists with new slug", TranslateRequest{Slug: "hello", NewSlug: "other"}, "", ErrorTranslationExists},
+		{"new slug taken", TranslateRequest{Slug: "world", NewSlug: "hello"}, "", ErrorSlugExists},
+		{"new slug", TranslateRequest{Slug: "world", NewSlug: "duniya"}, "duniya", nil},
+		{"slug text taken", TranslateRequest{Slug: "moon", SlugText: "Duniya"}, "duniya-2", nil},
+		{"missing source", TranslateRequest{Slug: "sun"}, "", api.ErrorNotFound},
+	}
+	for _, tt := range tests {
+		req := tt.req
+		req.Type, req.Language, req.Target = "article", "en", "hi"
+		resp, err := s.Translate(ctx, &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.err != nil {
+			if resp.Err != tt.err.Error() {
+				t.Errorf("%s: error %q, want %q", tt.name, resp.Err, tt.err)
+			}
+			continue
+		}
+		if resp.Err != "" {
+			t.Errorf("%s: error %q", tt.name, resp.Err)
+		} else if slug := responseSlug(resp); slug != tt.want {
+			t.Errorf("%s: slug %q, want %q", tt.name, slug, tt.want)
+		}
+	}
+
+	// The first item in Hindi isn't a translation of the English one
+	editor := WithUser(ctx, &User{Name: "e", Role: RoleEditor})
+	translations, _ := s.Translations(editor, &TranslationsRequest{Type: "article", Language: "en", Slug: "hello"})
+	hi, _ := translations.Translations["hi"].(map[string]interface{})
+	if slug, _ := hi["slug"].(string); slug != "hello-2" {
+		t.Errorf("translation of hello in hi is %q, want %q", slug, "hello-2")
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/endpoint"
+)
+
+// Trash - lists the deleted items
+func (s *Service) Trash(ctx context.Context, req *api.ListRequest) (*api.ListResults, error) {
+	var resp = api.ListResults{Type: req.Type, Request: req}
+
+	if !s.hasType(req.Type) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	size := req.Size
+	if size == 0 {
+		size = 10
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bb, err := s.bucket(tx, req.Type, req.Language)
+		if err != nil {
+			return err
+		}
+		c := bb.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var content map[string]interface{}
+			if err := json.Unmarshal(v, &content); err != nil {
+				return err
+			}
+			if !isTrashed(content) {
+				continue
+			}
+			resp.Total++
+			if resp.Total <= uint64(req.Skip) {
+				continue
+			}
+			if size < 0 || len(resp.List) < size {
+				resp.List = append(resp.List, content)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		resp.Err = err.Error()
+	}
+
+	return &resp, nil
+}
+
+// Restore - moves a single item out of trash
+func (s *Service) Restore(ctx context.Context, req *api.DeleteRequest) (*api.Response, error) {
+	s.langMu.RLock()
+	defer s.langMu.RUnlock()
+
+	var resp = api.Response{Type: req.Type, Language: req.Language}
+
+	if !s.hasType(req.Type) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bb, err := s.bucket(tx, req.Type, req.Language)
+		if err != nil {
+			return err
+		}
+
+		var content map[string]interface{}
+		val := bb.Get([]byte(req.Slug))
+		if val == nil {
+			return api.ErrorNotFound
+		}
+		err = json.Unmarshal(val, &content)
+		if err != nil {
+			return err
+		}
+		if !isTrashed(content) {
+			return api.ErrorNotFound
+		}
+
+		content["deleted_at"] = notDeleted
+		nextVersion(content, itemVersion(content))
+		j, err := json.Marshal(content)
+		if err != nil {
+			return err
+		}
+		err = bb.Put([]byte(req.Slug), j)
+		if err != nil {
+			return err
+		}
+		_, err = s.addRevision(ctx, tx, req.Type, req.Language, "restore", content)
+		if err != nil {
+			return err
+		}
+		err = putGroupMember(tx, req.Type, req.Language, content)
+		if err != nil {
+			return err
+		}
+
+		resp.Content = content
+
+		index, err := s.getIndex(req.Type, req.Language)
+		if err != nil {
+			return err
+		}
+		err = index.Index(req.Slug, content)
+		if err != nil {
+			return err
+		}
+		return syncRevision(tx, index, req.Type, req.Language)
+	})
+	if err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+
+	// Drop the cached responses
+	s.cache.invalidate(req.Type, req.Language)
+
+	return &resp, nil
+}
+
+// Purge - permanently deletes a single item, its history and its files
+func (s *Service) Purge(ctx context.Context, req *api.DeleteRequest) (*api.Response, error) {
+	s.langMu.RLock()
+	defer s.langMu.RUnlock()
+
+	var resp = api.Response{Type: req.Type, Language: req.Language}
+	var content map[string]interface{}
+
+	if !s.hasType(req.Type) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bb, err := s.bucket(tx, req.Type, req.Language)
+		if err != nil {
+			return err
+		}
+
+		val := bb.Get([]byte(req.Slug))
+		if val == nil {
+			return api.ErrorNotFound
+		}
+		err = json.Unmarshal(val, &content)
+		if err != nil {
+			return err
+		}
+		// Only trashed items can be purged
+		if !isTrashed(content) {
+			return api.ErrorNotFound
+		}
+		if err := checkIfMatch(ctx, content); err != nil {
+			return err
+		}
+		if err := checkVersion(ctx, content, nil); err != nil {
+			return err
+		}
+
+		err = bb.Delete([]byte(req.Slug))
+		if err != nil {
+			return err
+		}
+		id, _ := toInt64(content["id"])
+		err = deleteHistory(tx, req.Type, req.Language, id)
+		if err != nil {
+			return err
+		}
+		err = deleteRedirects(tx, req.Type, req.Language, req.Slug)
+		if err != nil {
+			return err
+		}
+		err = deleteGroupMember(tx, req.Type, req.Language, content)
+		if err != nil {
+			return err
+		}
+
+		resp.Content = content
+
+		index, err := s.getIndex(req.Type, req.Language)
+		if err != nil {
+			return err
+		}
+		err = index.Delete(req.Slug)
+		if err != nil {
+			return err
+		}
+		return syncRevision(tx, index, req.Type, req.Language)
+	})
+	if err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+
+	// Drop the cached responses
+	s.cache.invalidate(req.Type, req.Language)
+
+	// Remove uploaded files once the item is gone
+	if id, ok := toInt64(content["id"]); ok {
+		if err := deleteFiles(ctx, s.Storage, itemDir(req.Type, req.Language, id)+"/"); err != nil {
+			resp.Err = err.Error()
+		}
+	}
+
+	return &resp, nil
+}
+
+// TrashEndpoint - creates endpoint for Trash service
+func TrashEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(api.ListRequest)
+		return svc.Trash(ctx, &req)
+	}
+}
+
+// RestoreEndpoint - creates endpoint for Restore service
+func RestoreEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(api.DeleteRequest)
+		return svc.Restore(ctx, &req)
+	}
+}
+
+// PurgeEndpoint - creates endpoint for Purge service
+func PurgeEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(api.DeleteRequest)
+		return svc.Purge(ctx, &req)
+	}
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+)
+
+func TestPurge(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	if resp, _ := s.Create(ctx, &api.CreateRequest{Type: "article", Language: "en", Slug: "a", Content: fileContent("a.txt", "hi")}, false); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	req := &api.DeleteRequest{Type: "article", Language: "en", Slug: "a"}
+
+	// Items must be trashed before they are purged
+	if resp, _ := s.Purge(ctx, req); resp.Err != api.ErrorNotFound.Error() {
+		t.Fatalf("purge of live item: error %q, want %q", resp.Err, api.ErrorNotFound)
+	}
+	deleted, _ := s.Delete(ctx, req, false)
+	if deleted.Err != "" {
+		t.Fatal(deleted.Err)
+	}
+	trashed, _ := deleted.Content.(map[string]interface{})
+
+	ifMatch := func(etag string) context.Context {
+		r := httptest.NewRequest("POST", "/purge", nil)
+		r.Header.Set("If-Match", etag)
+		return Conditions(OpPurge)(ctx, r)
+	}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+	}{
+		{"stale version", WithVersion(ctx, itemVersion(trashed)-1), ErrorConflict},
+		{"stale etag", ifMatch(`"stale"`), ErrorPreconditionFailed},
+		{"current etag", ifMatch(contentETag(trashed)), nil},
+		{"purged", ctx, api.ErrorNotFound},
+	}
+	for _, tt := range tests {
+		resp, _ := s.Purge(tt.ctx, req)
+		if tt.err == nil && resp.Err != "" || tt.err != nil && resp.Err != tt.err.Error() {
+			t.Errorf("%s: error %q, want %v", tt.name, resp.Err, tt.err)
+		}
+	}
+
+	if _, err := s.Storage.Stat(ctx, "article/en/1/a.txt"); err != ErrorFileNotFound {
+		t.Errorf("file of purged item: %v", err)
+	}
+}
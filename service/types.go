@@ -0,0 +1,467 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	"git.urantiatech.com/cloudcms/cloudcms/item"
+	"github.com/blevesearch/bleve"
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/endpoint"
+)
+
+// TypesBucket holds the definitions of the content types by name
+const TypesBucket = "_types"
+
+// typesKey is the MetaBucket key holding the names of the content types of
+// item.Types already imported into TypesBucket
+var typesKey = []byte("types")
+
+// Content type errors
+var (
+	ErrorInvalidTypeName = errors.New("Invalid content type name")
+	ErrorTypeExists      = errors.New("Content type already exists")
+	ErrorTypeNotEmpty    = errors.New("Content type is not empty")
+)
+
+// typeName is the pattern of the names of content types created at runtime,
+// names starting with "_" are reserved for internal buckets
+var typeName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// fieldTypes are the field types checked by validate
+var fieldTypes = map[string]bool{
+	"string": true, "text": true, "textarea": true, "html": true,
+	"markdown": true, "email": true, "url": true, "int": true,
+	"integer": true, "number": true, "float": true, "bool": true,
+	"boolean": true, "date": true, "datetime": true, "time": true,
+	"file": true, "list": true, "array": true, "tags": true,
+}
+
+// TypeDefinition is the stored definition of a content type
+type TypeDefinition struct {
+	Name   string  `json:"name"`
+	Fields []Field `json:"fields"`
+}
+
+// TypeResponse returns the definition of a content type, Errors lists the
+// invalid field definitions
+type TypeResponse struct {
+	Name   string       `json:"name"`
+	Fields []Field      `json:"fields"`
+	Errors []FieldError `json:"errors,omitempty"`
+	Err    string       `json:"error,omitempty"`
+}
+
+// loadTypes returns the stored content type definitions. The types of
+// item.Types are imported the first time they are seen, they are managed
+// at runtime afterwards.
+func loadTypes(tx *bolt.Tx, meta *bolt.Bucket) ([]TypeDefinition, error) {
+	b, err := tx.CreateBucketIfNotExists([]byte(TypesBucket))
+	if err != nil {
+		return nil, err
+	}
+
+	var imported []string
+	if v := meta.Get(typesKey); v != nil {
+		if err := json.Unmarshal(v, &imported); err != nil {
+			return nil, err
+		}
+	}
+	done := make(map[string]bool)
+	for _, t := range imported {
+		done[t] = true
+	}
+
+	compiled := loadFields()
+	var names []string
+	for t := range item.Types {
+		if !done[t] {
+			names = append(names, t)
+		}
+	}
+	sort.Strings(names)
+	for _, t := range names {
+		if b.Get([]byte(t)) == nil {
+			j, err := json.Marshal(TypeDefinition{Name: t, Fields: compiled[t]})
+			if err != nil {
+				return nil, err
+			}
+			if err := b.Put([]byte(t), j); err != nil {
+				return nil, err
+			}
+		}
+		imported = append(imported, t)
+	}
+	if len(names) > 0 {
+		j, err := json.Marshal(imported)
+		if err != nil {
+			return nil, err
+		}
+		if err := meta.Put(typesKey, j); err != nil {
+			return nil, err
+		}
+	}
+
+	var defs []TypeDefinition
+	err = b.ForEach(func(k, v []byte) error {
+		var def TypeDefinition
+		if err := json.Unmarshal(v, &def); err != nil {
+			return err
+		}
+		def.Name = string(k)
+		defs = append(defs, def)
+		return nil
+	})
+	return defs, err
+}
+
+// fieldsOf returns the field definitions of content type
+func (s *Service) fieldsOf(contentType string) []Field {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.fields[contentType]
+}
+
+// checkFields validates field definitions
+func checkFields(fields []Field) error {
+	var errs ValidationError
+	seen := make(map[string]bool)
+	for i, f := range fields {
+		name := f.Name
+		if name == "" {
+			name = fmt.Sprintf("fields[%d]", i)
+			errs = append(errs, FieldError{Field: name, Error: "name is required"})
+		} else if systemFields[name] || strings.HasPrefix(name, "file:") {
+			errs = append(errs, FieldError{Field: name, Error: "name is reserved"})
+		} else if seen[name] {
+			errs = append(errs, FieldError{Field: name, Error: "is defined twice"})
+		}
+		seen[name] = true
+
+		if !fieldTypes[f.Type] {
+			errs = append(errs, FieldError{Field: name, Error: "unknown type " + f.Type})
+		}
+		if f.Pattern != "" {
+			if _, err := regexp.Compile(f.Pattern); err != nil {
+				errs = append(errs, FieldError{Field: name, Error: "invalid pattern"})
+			}
+		}
+		if f.Analyzer != "" {
+			m := bleve.NewIndexMapping()
+			m.DefaultAnalyzer = f.Analyzer
+			if err := m.Validate(); err != nil {
+				errs = append(errs, FieldError{Field: name, Error: "unknown analyzer " + f.Analyzer})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// typeError sets the error of a content type response
+func typeError(resp *TypeResponse, err error) {
+	if errs, ok := err.(ValidationError); ok {
+		resp.Err = ErrorValidation.Error()
+		resp.Errors = errs
+		return
+	}
+	resp.Err = err.Error()
+}
+
+// putType stores the definition of a content type
+func putType(tx *bolt.Tx, def *TypeDefinition) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(TypesBucket))
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(def.Name), j)
+}
+
+// CreateType - creates a content type, its buckets and indexes
+func (s *Service) CreateType(ctx context.Context, req *TypeDefinition) (*TypeResponse, error) {
+	var resp = TypeResponse{Name: req.Name, Fields: req.Fields}
+
+	if !typeName.MatchString(req.Name) {
+		resp.Err = ErrorInvalidTypeName.Error()
+		return &resp, nil
+	}
+	if err := checkFields(req.Fields); err != nil {
+		typeError(&resp, err)
+		return &resp, nil
+	}
+
+	s.langMu.Lock()
+	defer s.langMu.Unlock()
+
+	if s.hasType(req.Name) {
+		resp.Err = ErrorTypeExists.Error()
+		return &resp, nil
+	}
+
+	// The type is served once its buckets and indexes are complete
+	fields := compileFields(req.Fields)
+	languages := s.languages()
+	indexes := make(map[string]bleve.Index)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(req.Name)); b != nil {
+			// Content is left over by a type of the same name
+			return ErrorTypeExists
+		}
+		if err := putType(tx, req); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket([]byte(req.Name))
+		if err != nil {
+			return err
+		}
+		for _, l := range languages {
+			if _, err := b.CreateBucket([]byte(l.String())); err != nil {
+				return err
+			}
+			m, err := indexMapping(l.String(), fields)
+			if err != nil {
+				return err
+			}
+			var path string
+			if s.IndexFile != "" {
+				path = s.indexPath(req.Name, l.String())
+			}
+			if indexes[l.String()], err = newIndex(path, m); err != nil {
+				return err
+			}
+			// Record the revision of the empty index
+			if err := fillIndex(tx, req.Name, l.String(), indexes[l.String()]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, index := range indexes {
+			if index != nil {
+				index.Close()
+			}
+		}
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+
+	s.mu.Lock()
+	s.fields[req.Name] = fields
+	s.index[req.Name] = indexes
+	s.mu.Unlock()
+	log.Printf("Created content type %s", req.Name)
+	return &resp, nil
+}
+
+// UpdateType - replaces the field definitions of a content type, indexes
+// are rebuilt if their analyzers changed
+func (s *Service) UpdateType(ctx context.Context, req *TypeDefinition) (*TypeResponse, error) {
+	var resp = TypeResponse{Name: req.Name, Fields: req.Fields}
+
+	if err := checkFields(req.Fields); err != nil {
+		typeError(&resp, err)
+		return &resp, nil
+	}
+
+	s.langMu.Lock()
+	defer s.langMu.Unlock()
+
+	if !s.hasType(req.Name) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	// Signatures of the current index mappings
+	languages := s.languages()
+	signatures := make(map[string][]byte)
+	for _, l := range languages {
+		m, err := s.newIndexMapping(req.Name, l.String())
+		if err != nil {
+			resp.Err = err.Error()
+			return &resp, nil
+		}
+		if signatures[l.String()], err = mappingSignature(m); err != nil {
+			resp.Err = err.Error()
+			return &resp, nil
+		}
+	}
+
+	// Indexes whose analyzers changed are rebuilt in the transaction and
+	// served once it is committed, before writes resume
+	fields := compileFields(req.Fields)
+	rebuilt := make(map[string]bleve.Index)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := putType(tx, req); err != nil {
+			return err
+		}
+		for _, l := range languages {
+			m, err := indexMapping(l.String(), fields)
+			if err != nil {
+				return err
+			}
+			signature, err := mappingSignature(m)
+			if err != nil {
+				return err
+			}
+			if string(signature) == string(signatures[l.String()]) {
+				continue
+			}
+			log.Printf("Rebuilding index %s/%s", req.Name, l.String())
+			if rebuilt[l.String()], err = s.buildIndex(tx, req.Name, l.String(), m); err != nil {
+				delete(rebuilt, l.String())
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for l, index := range rebuilt {
+			s.discardIndex(req.Name, l, index)
+		}
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+	s.mu.Lock()
+	s.fields[req.Name] = fields
+	s.mu.Unlock()
+	for l, index := range rebuilt {
+		if err := s.swapIndex(req.Name, l, index); err != nil {
+			resp.Err = err.Error()
+		}
+	}
+	log.Printf("Updated content type %s", req.Name)
+
+	for _, l := range languages {
+		s.cache.invalidate(req.Name, l.String())
+	}
+	return &resp, nil
+}
+
+// DeleteType - deletes a content type without content in any language,
+// including disabled ones
+func (s *Service) DeleteType(ctx context.Context, req *TypeDefinition) (*TypeResponse, error) {
+	var resp = TypeResponse{Name: req.Name}
+
+	s.langMu.Lock()
+	defer s.langMu.Unlock()
+
+	if !s.hasType(req.Name) {
+		resp.Err = api.ErrorInvalidContentType.Error()
+		return &resp, nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var languages []string
+		if b := tx.Bucket([]byte(req.Name)); b != nil {
+			err := b.ForEach(func(k, v []byte) error {
+				if bb := b.Bucket(k); bb != nil {
+					if k, _ := bb.Cursor().First(); k != nil {
+						return ErrorTypeNotEmpty
+					}
+					languages = append(languages, string(k))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if err := tx.DeleteBucket([]byte(req.Name)); err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket([]byte(TypesBucket)); b != nil {
+			if err := b.Delete([]byte(req.Name)); err != nil {
+				return err
+			}
+		}
+		if meta := tx.Bucket([]byte(MetaBucket)); meta != nil {
+			for _, l := range languages {
+				if err := meta.Delete(revisionName(req.Name, l)); err != nil {
+					return err
+				}
+			}
+		}
+		for _, name := range []string{HistoryBucket, RedirectBucket, GroupBucket} {
+			if b := tx.Bucket([]byte(name)); b != nil && b.Bucket([]byte(req.Name)) != nil {
+				if err := b.DeleteBucket([]byte(req.Name)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+
+	s.mu.Lock()
+	closing := s.index[req.Name]
+	delete(s.index, req.Name)
+	delete(s.fields, req.Name)
+	s.mu.Unlock()
+
+	for l, index := range closing {
+		if err := index.Close(); err != nil {
+			resp.Err = err.Error()
+		}
+		s.cache.invalidate(req.Name, l)
+	}
+	if s.IndexFile != "" {
+		if err := os.RemoveAll(filepath.Join(s.IndexFile, req.Name)); err != nil {
+			resp.Err = err.Error()
+		}
+	}
+	log.Printf("Deleted content type %s", req.Name)
+	return &resp, nil
+}
+
+// CreateTypeEndpoint - creates endpoint for CreateType service
+func CreateTypeEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(TypeDefinition)
+		return svc.CreateType(ctx, &req)
+	}
+}
+
+// UpdateTypeEndpoint - creates endpoint for UpdateType service
+func UpdateTypeEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(TypeDefinition)
+		return svc.UpdateType(ctx, &req)
+	}
+}
+
+// DeleteTypeEndpoint - creates endpoint for DeleteType service
+func DeleteTypeEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(TypeDefinition)
+		return svc.DeleteType(ctx, &req)
+	}
+}
+
+// DecodeTypeReq - decodes the incoming request
+func DecodeTypeReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request TypeDefinition
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	"github.com/blevesearch/bleve"
+	"github.com/boltdb/bolt"
+)
+
+func TestTypeLifecycle(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	// Persistent indexes are moved in place when they are rebuilt
+	s.IndexFile = filepath.Join(t.TempDir(), "index")
+
+	for _, tt := range []struct {
+		def TypeDefinition
+		err error
+	}{
+		{TypeDefinition{Name: "_meta"}, ErrorInvalidTypeName},
+		{TypeDefinition{Name: "Page"}, ErrorInvalidTypeName},
+		{TypeDefinition{Name: "article"}, ErrorTypeExists},
+		{TypeDefinition{Name: "page", Fields: []Field{{Name: "title", Type: "text"}}}, nil},
+	} {
+		resp, _ := s.CreateType(ctx, &tt.def)
+		if tt.err == nil && resp.Err != "" || tt.err != nil && resp.Err != tt.err.Error() {
+			t.Errorf("CreateType(%s): error %q, want %v", tt.def.Name, resp.Err, tt.err)
+		}
+	}
+	if resp, _ := s.Create(ctx, &api.CreateRequest{Type: "page", Language: "en", Slug: "a", Content: map[string]interface{}{"title": "Running"}}, false); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+
+	// Changing the analyzer rebuilds the index once the type is stored
+	previous, _ := s.getIndex("page", "en")
+	def := &TypeDefinition{Name: "page", Fields: []Field{{Name: "title", Type: "text", Analyzer: "keyword"}}}
+	if resp, _ := s.UpdateType(ctx, def); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	index, _ := s.getIndex("page", "en")
+	if index == previous {
+		t.Fatal("index not rebuilt")
+	}
+	if _, err := previous.DocCount(); err == nil {
+		t.Error("previous index left open")
+	}
+	query := bleve.NewMatchQuery("Running")
+	query.SetField("title")
+	if res, err := index.Search(bleve.NewSearchRequest(query)); err != nil || res.Total != 1 {
+		t.Errorf("search of rebuilt index: %v, %v", res, err)
+	}
+	if _, err := os.Stat(s.indexPath("page", "en")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(s.indexPath("page", "en") + ".new"); !os.IsNotExist(err) {
+		t.Errorf("new index left at %s.new", s.indexPath("page", "en"))
+	}
+
+	// Types are deleted once their items are purged
+	req := &api.DeleteRequest{Type: "page", Language: "en", Slug: "a"}
+	if resp, _ := s.DeleteType(ctx, &TypeDefinition{Name: "page"}); resp.Err != ErrorTypeNotEmpty.Error() {
+		t.Errorf("DeleteType of type with items: error %q", resp.Err)
+	}
+	s.Delete(ctx, req, false)
+	if resp, _ := s.DeleteType(ctx, &TypeDefinition{Name: "page"}); resp.Err != ErrorTypeNotEmpty.Error() {
+		t.Errorf("DeleteType of type with trashed items: error %q", resp.Err)
+	}
+	s.Purge(ctx, req)
+	if resp, _ := s.DeleteType(ctx, &TypeDefinition{Name: "page"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if s.hasType("page") {
+		t.Error("deleted type still served")
+	}
+	s.db.View(func(tx *bolt.Tx) error {
+		for _, name := range []string{HistoryBucket, GroupBucket, RedirectBucket} {
+			if b := tx.Bucket([]byte(name)); b != nil && b.Bucket([]byte("page")) != nil {
+				t.Errorf("%s/page left", name)
+			}
+		}
+		return nil
+	})
+	if _, err := os.Stat(filepath.Join(s.IndexFile, "page")); !os.IsNotExist(err) {
+		t.Error("indexes of deleted type left")
+	}
+	if resp, _ := s.UpdateType(ctx, def); resp.Err != api.ErrorInvalidContentType.Error() {
+		t.Errorf("UpdateType of deleted type: error %q", resp.Err)
+	}
+}
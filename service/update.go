@@ -4,11 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
-	"io"
-	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -20,24 +16,22 @@ import (
 
 // Update - creates a single item
 func (s *Service) Update(ctx context.Context, req *api.UpdateRequest, sync bool) (*api.Response, error) {
+	s.langMu.RLock()
+	defer s.langMu.RUnlock()
+
 	var resp = api.Response{Type: req.Type, Language: req.Language}
-	var db *bolt.DB
+	var previous map[string]interface{}
 	var err error
 
-	if _, ok := Index[req.Type]; !ok {
+	if !s.hasType(req.Type) {
 		resp.Err = api.ErrorInvalidContentType.Error()
 		return &resp, nil
 	}
 
-	// Open database in read-write mode
-	db, err = bolt.Open(DBFile, 0644, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		bb, err := getBucket(tx, req.Type, req.Language)
+	var changes fileChanges
+	var pruned map[string]bool
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bb, err := s.bucket(tx, req.Type, req.Language)
 		if err != nil {
 			return err
 		}
@@ -52,13 +46,58 @@ func (s *Service) Update(ctx context.Context, req *api.UpdateRequest, sync bool)
 		if err != nil {
 			return err
 		}
+		if isTrashed(content) {
+			return api.ErrorNotFound
+		}
+		if err := checkIfMatch(ctx, content); err != nil {
+			return err
+		}
 
 		// Update values
 		if req.Content == nil {
 			return api.ErrorNullContent
 		}
 
+		var current = make(map[string]interface{})
+		for k, v := range content {
+			current[k] = v
+		}
+		previous = current
+
 		var fields = (req.Content).(map[string]interface{})
+
+		// Ignore the fields maintained by the service, clients may send
+		// back the item they read
+		var system = make(map[string]interface{})
+		for k, v := range fields {
+			if readOnlyFields[k] {
+				system[k] = v
+				delete(fields, k)
+			}
+		}
+
+		// Check the updated content before writing any file, the files
+		// are removed again if the update isn't committed
+		var merged = make(map[string]interface{})
+		for k, v := range content {
+			merged[k] = v
+		}
+		for k, v := range fields {
+			merged[k] = v
+		}
+		if err := s.validate(req.Type, merged); err != nil {
+			return err
+		}
+		if err := checkWorkflow(current, merged); err != nil {
+			return err
+		}
+
+		// Reject the update if the item changed since the client read it,
+		// the version sent back with the item is the one expected
+		if err := checkVersion(ctx, current, system); err != nil {
+			return err
+		}
+
 		for k, v := range fields {
 
 			// Update file
@@ -74,28 +113,27 @@ func (s *Service) Update(ctx context.Context, req *api.UpdateRequest, sync bool)
 
 				// Update only if new file was uploaded
 				if len(file.Bytes) > 0 {
-					file.URI = fmt.Sprintf("/drive/%s/%s/%d/%s", req.Type, req.Language, id, file.Name)
-
-					filemap := v.(map[string]interface{})
-					filemap["uri"] = file.URI
-					filemap["bytes"] = nil
-
-					// Create path
-					path := fmt.Sprintf("drive/%s/%s/%d", req.Type, req.Language, id)
-					if err := os.MkdirAll(path, os.ModeDir|os.ModePerm); err != nil {
+					name, err := fileName(file.Name)
+					if err != nil {
 						return err
 					}
 
-					// Create file
-					dst, err := os.Create(path + "/" + file.Name)
+					// Store the uploaded file
+					buff := bytes.NewReader(file.Bytes)
+					stored, err := s.writeFile(ctx, itemDir(req.Type, req.Language, id), name, buff, int64(len(file.Bytes)), s.fileRule(req.Type, k))
 					if err != nil {
 						return err
 					}
-					defer dst.Close()
-
-					// Copy the uploaded file to the destination file
-					buff := bytes.NewBuffer(file.Bytes)
-					if _, err := io.Copy(dst, buff); err != nil {
+					key, _ := fileKey(stored.URI)
+					changes.written = append(changes.written, key)
+					setFile(v.(map[string]interface{}), stored)
+				} else if prev, ok := content[k].(map[string]interface{}); ok && file.URI != "" && prev["uri"] == file.URI {
+					// Keep the metadata of the unchanged file
+					v = prev
+				} else if file.URI != "" {
+					// Check the stored file, staged uploads are moved into
+					// the item directory
+					if err := s.adoptUpload(ctx, v.(map[string]interface{}), req.Type, req.Language, id, s.fileRule(req.Type, k), &changes); err != nil {
 						return err
 					}
 				}
@@ -107,6 +145,7 @@ func (s *Service) Update(ctx context.Context, req *api.UpdateRequest, sync bool)
 
 		}
 		content["updated_at"] = time.Now().Unix()
+		nextVersion(content, itemVersion(current))
 
 		// Commit to database
 		j, err := json.Marshal(content)
@@ -117,10 +156,18 @@ func (s *Service) Update(ctx context.Context, req *api.UpdateRequest, sync bool)
 		if err != nil {
 			return err
 		}
+		pruned, err = s.addRevision(ctx, tx, req.Type, req.Language, "update", content)
+		if err != nil {
+			return err
+		}
+		err = putGroupMember(tx, req.Type, req.Language, content)
+		if err != nil {
+			return err
+		}
 
 		resp.Content = content
 
-		index, err := getIndex(req.Type, req.Language)
+		index, err := s.getIndex(req.Type, req.Language)
 		if err != nil {
 			return err
 		}
@@ -128,23 +175,38 @@ func (s *Service) Update(ctx context.Context, req *api.UpdateRequest, sync bool)
 		if err != nil {
 			return err
 		}
+		err = syncRevision(tx, index, req.Type, req.Language)
+		if err != nil {
+			return err
+		}
 
 		return nil
 	})
 	if err != nil {
-		resp.Err = err.Error()
+		changes.undo(ctx, s.Storage)
+		resp.Err, resp.Content = validationResponse(err)
+		if err == ErrorConflict {
+			// Let the client show the current item
+			resp.Content = previous
+		}
 		return &resp, nil
 	}
 
-	// Update the cache
-	key := fmt.Sprintf("%s.%s.%s", req.Language, req.Type, req.Slug)
-	RespCache.Delete(key)
+	// Drop the cached responses
+	s.cache.invalidate(req.Type, req.Language)
+
+	// Remove the files replaced by the update or only referenced by the
+	// pruned revisions
+	content, _ := resp.Content.(map[string]interface{})
+	if err := s.deleteReplaced(ctx, req.Type, req.Language, previous, content, pruned); err != nil {
+		resp.Err = err.Error()
+	}
 
 	return &resp, nil
 }
 
 // UpdateEndpoint - creates endpoint for Update service
-func UpdateEndpoint(svc Service) endpoint.Endpoint {
+func UpdateEndpoint(svc *Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(api.UpdateRequest)
 		return svc.Update(ctx, &req, false)
@@ -0,0 +1,719 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/gorilla/mux"
+)
+
+// UploadBucket holds the resumable upload sessions
+const UploadBucket = "_uploads"
+
+// Upload errors
+var (
+	ErrorNoFile          = errors.New("No file uploaded")
+	ErrorUploadNotFound  = errors.New("Upload not found")
+	ErrorUploadOffset    = errors.New("Upload offset mismatch")
+	ErrorUploadTooLarge  = errors.New("Upload exceeds declared size")
+	ErrorInvalidUpload   = errors.New("Invalid upload")
+	ErrorInvalidFileName = errors.New("Invalid file name")
+	ErrorInvalidFileURI  = errors.New("Invalid file URI")
+	ErrorFileType        = errors.New("File type not allowed")
+	ErrorFileTooLarge    = errors.New("File too large")
+)
+
+// maxFileName is the maximum length of a stored file name
+const maxFileName = 100
+
+// File is the metadata of an uploaded file, it is stored in "file:" fields
+type File struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	URI         string `json:"uri"`
+	ContentType string `json:"content_type,omitempty"`
+	Checksum    string `json:"sha256,omitempty"`
+}
+
+// UploadRequest streams the files of a multipart/form-data body. Files are
+// staged until referenced by a create or update request, Slug checks that
+// the item to update exists.
+type UploadRequest struct {
+	Type     string
+	Language string
+	Slug     string
+	reader   *multipart.Reader
+}
+
+// UploadResponse returns the metadata of the uploaded files keyed by form field
+type UploadResponse struct {
+	Type     string          `json:"type"`
+	Language string          `json:"language"`
+	Slug     string          `json:"slug,omitempty"`
+	Files    map[string]File `json:"files"`
+	Err      string          `json:"error,omitempty"`
+}
+
+// UploadSession is a resumable upload of a single file
+type UploadSession struct {
+	Token     string `json:"token"`
+	Type      string `json:"type"`
+	Language  string `json:"language"`
+	Slug      string `json:"slug,omitempty"`
+	Field     string `json:"field,omitempty"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Offset    int64  `json:"offset"`
+	CreatedAt int64  `json:"created_at"`
+	File      *File  `json:"file,omitempty"`
+	Err       string `json:"error,omitempty"`
+}
+
+// ChunkRequest appends a chunk at Offset to a resumable upload, Type is
+// set from the upload session by UploadSessionType
+type ChunkRequest struct {
+	Token  string
+	Offset int64
+	Type   string
+	body   io.Reader
+}
+
+// stagingDir is the storage prefix of uploads not yet referenced by an item
+func stagingDir(contentType, language string) string {
+	return fmt.Sprintf("%s/%s/uploads", contentType, language)
+}
+
+// itemDir is the storage prefix of the files of an item
+func itemDir(contentType, language string, id int64) string {
+	return fmt.Sprintf("%s/%s/%d", contentType, language, id)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// uploadDir returns a new staging directory receiving uploads, the item
+// under slug must exist if slug isn't empty. Uploads for an existing item
+// are staged too, they are moved into the item directory by the update
+// referencing them and collected otherwise.
+func (s *Service) uploadDir(contentType, language, slug string) (string, error) {
+	if _, err := s.getIndex(contentType, language); err != nil {
+		return "", err
+	}
+	if slug != "" {
+		err := s.db.View(func(tx *bolt.Tx) error {
+			bb, err := s.bucket(tx, contentType, language)
+			if err != nil {
+				return err
+			}
+			if bb.Get([]byte(slug)) == nil {
+				return api.ErrorNotFound
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	return stagingDir(contentType, language) + "/" + token, nil
+}
+
+// fileName turns a client supplied file name into a slug keeping its
+// extension, e.g. "../My Photo.JPG" becomes "my-photo.jpg"
+func fileName(name string) (string, error) {
+	name = path.Base(strings.Replace(name, "\\", "/", -1))
+	ext := path.Ext(name)
+	stem := slugify(strings.TrimSuffix(name, ext), "", true)
+	ext = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return -1
+	}, ext)
+
+	if stem == "" && ext == "" {
+		return "", ErrorInvalidFileName
+	}
+	if stem == "" {
+		stem = "file"
+	}
+	if len(ext) > 10 {
+		ext = ext[:10]
+	}
+	if len(stem)+len(ext)+1 > maxFileName {
+		stem = strings.TrimRight(stem[:maxFileName-len(ext)-1], "-")
+	}
+	if ext != "" {
+		return stem + "." + ext, nil
+	}
+	return stem, nil
+}
+
+// sniff returns the content type detected from the first bytes of r and
+// a reader returning all bytes of r
+func sniff(r io.Reader) (string, io.Reader, error) {
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", nil, err
+	}
+	return http.DetectContentType(head), br, nil
+}
+
+// countingWriter counts the bytes written
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// writeFile streams r into the storage under dir/name and returns its
+// metadata, size is -1 if unknown. The type and size of the file are
+// checked against the field definition rule if it isn't nil.
+func (s *Service) writeFile(ctx context.Context, dir, name string, r io.Reader, size int64, rule *Field) (*File, error) {
+	if rule != nil && rule.MaxSize > 0 && size > rule.MaxSize {
+		return nil, ErrorFileTooLarge
+	}
+	contentType, r, err := sniff(r)
+	if err != nil {
+		return nil, err
+	}
+	if rule != nil && !rule.accepts(contentType) {
+		return nil, ErrorFileType
+	}
+	if rule != nil && rule.MaxSize > 0 {
+		// Read one byte more than allowed to detect large files
+		r = io.LimitReader(r, rule.MaxSize+1)
+	}
+
+	hash := sha256.New()
+	var n countingWriter
+	key, err := s.freeKey(ctx, dir, name)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Storage.Put(ctx, key, io.TeeReader(r, io.MultiWriter(hash, &n)), size, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if rule != nil && rule.MaxSize > 0 && int64(n) > rule.MaxSize {
+		s.Storage.Delete(ctx, key)
+		return nil, ErrorFileTooLarge
+	}
+	return &File{
+		Name:        name,
+		Size:        int64(n),
+		URI:         fileURI(key),
+		ContentType: contentType,
+		Checksum:    hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// freeKey returns the key of name in dir, suffixed before the extension if
+// the key is taken. Stored files are never overwritten, revisions may
+// still reference them.
+func (s *Service) freeKey(ctx context.Context, dir, name string) (string, error) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	key := dir + "/" + name
+	for i := 2; ; i++ {
+		_, err := s.Storage.Stat(ctx, key)
+		if err == ErrorFileNotFound {
+			return key, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		key = fmt.Sprintf("%s/%s-%d%s", dir, base, i, ext)
+	}
+}
+
+// fileChanges records the files stored while writing an item, they are
+// undone if the item isn't committed
+type fileChanges struct {
+	written []string
+	moved   [][2]string
+}
+
+// undo removes the written files and moves the adopted uploads back to
+// their staging directory
+func (c *fileChanges) undo(ctx context.Context, st Storage) {
+	for _, key := range c.written {
+		if err := st.Delete(ctx, key); err != nil {
+			log.Printf("Removing %s: %v", key, err)
+		}
+	}
+	for _, m := range c.moved {
+		if err := moveFile(ctx, st, m[1], m[0]); err != nil {
+			log.Printf("Restoring %s: %v", m[0], err)
+		}
+	}
+}
+
+// Upload - streams the files of a multipart request to disk
+func (s *Service) Upload(ctx context.Context, req *UploadRequest) (*UploadResponse, error) {
+	var resp = UploadResponse{Type: req.Type, Language: req.Language, Slug: req.Slug, Files: make(map[string]File)}
+
+	dir, err := s.uploadDir(req.Type, req.Language, req.Slug)
+	if err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+
+	for {
+		part, err := req.reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			resp.Err = err.Error()
+			return &resp, nil
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		name, err := fileName(part.FileName())
+		if err != nil {
+			resp.Err = err.Error()
+			return &resp, nil
+		}
+		file, err := s.writeFile(ctx, dir, name, part, -1, s.fileRule(req.Type, part.FormName()))
+		if err != nil {
+			resp.Err = err.Error()
+			return &resp, nil
+		}
+		resp.Files[part.FormName()] = *file
+	}
+
+	if len(resp.Files) == 0 {
+		resp.Err = ErrorNoFile.Error()
+	}
+	return &resp, nil
+}
+
+// tokenLocks serializes the chunks of each resumable upload
+type tokenLocks struct {
+	mu    sync.Mutex
+	locks map[string]*tokenLock
+}
+
+// tokenLock is released when no chunk of its upload is pending
+type tokenLock struct {
+	sync.Mutex
+	pending int
+}
+
+// lock waits for the chunks of upload token in progress and returns the
+// function releasing the lock
+func (l *tokenLocks) lock(token string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*tokenLock)
+	}
+	tl := l.locks[token]
+	if tl == nil {
+		tl = &tokenLock{}
+		l.locks[token] = tl
+	}
+	tl.pending++
+	l.mu.Unlock()
+
+	tl.Lock()
+	return func() {
+		tl.Unlock()
+		l.mu.Lock()
+		if tl.pending--; tl.pending == 0 {
+			delete(l.locks, token)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// chunkPath is the local file receiving the chunks of a resumable upload
+func (s *Service) chunkPath(token string) string {
+	return filepath.Join(s.UploadDir, token)
+}
+
+func getSession(tx *bolt.Tx, token string) (*UploadSession, error) {
+	b := tx.Bucket([]byte(UploadBucket))
+	if b == nil {
+		return nil, ErrorUploadNotFound
+	}
+	val := b.Get([]byte(token))
+	if val == nil {
+		return nil, ErrorUploadNotFound
+	}
+	var session UploadSession
+	if err := json.Unmarshal(val, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func putSession(tx *bolt.Tx, session *UploadSession) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(UploadBucket))
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(session.Token), j)
+}
+
+// StartUpload - creates a resumable upload session for a single file
+func (s *Service) StartUpload(ctx context.Context, req *UploadSession) (*UploadSession, error) {
+	var resp = *req
+	var err error
+
+	if _, err := s.getIndex(req.Type, req.Language); err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+	if resp.Name, err = fileName(req.Name); err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+	if req.Size <= 0 {
+		resp.Err = ErrorInvalidUpload.Error()
+		return &resp, nil
+	}
+	if rule := s.fileRule(req.Type, req.Field); rule != nil && rule.MaxSize > 0 && req.Size > rule.MaxSize {
+		resp.Err = ErrorFileTooLarge.Error()
+		return &resp, nil
+	}
+	if resp.Token, err = newToken(); err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+	resp.Offset = 0
+	resp.CreatedAt = time.Now().Unix()
+	resp.File = nil
+
+	if err := os.MkdirAll(s.UploadDir, os.ModeDir|os.ModePerm); err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+	f, err := os.Create(s.chunkPath(resp.Token))
+	if err != nil {
+		resp.Err = err.Error()
+		return &resp, nil
+	}
+	f.Close()
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return putSession(tx, &resp)
+	})
+	if err != nil {
+		resp.Err = err.Error()
+	}
+	return &resp, nil
+}
+
+// UploadStatus - returns the session of a resumable upload
+func (s *Service) UploadStatus(ctx context.Context, req *ChunkRequest) (*UploadSession, error) {
+	var session *UploadSession
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		session, err = getSession(tx, req.Token)
+		return err
+	})
+	if err != nil {
+		return &UploadSession{Token: req.Token, Err: err.Error()}, nil
+	}
+	return session, nil
+}
+
+// UploadChunk - appends a chunk to a resumable upload, the file is stored
+// once all bytes were received
+func (s *Service) UploadChunk(ctx context.Context, req *ChunkRequest) (*UploadSession, error) {
+	// Concurrent chunks of an upload would write at the same offset
+	defer s.uploads.lock(req.Token)()
+
+	resp, _ := s.UploadStatus(ctx, req)
+	if resp.Err != "" {
+		return resp, nil
+	}
+	if req.Offset != resp.Offset {
+		resp.Err = ErrorUploadOffset.Error()
+		return resp, nil
+	}
+
+	f, err := os.OpenFile(s.chunkPath(req.Token), os.O_WRONLY, 0644)
+	if err != nil {
+		resp.Err = err.Error()
+		return resp, nil
+	}
+	var n int64
+	if _, err = f.Seek(resp.Offset, io.SeekStart); err == nil {
+		// Accept one byte more than expected to detect oversized uploads
+		n, err = io.Copy(f, io.LimitReader(req.body, resp.Size-resp.Offset+1))
+	}
+	f.Close()
+	if err == nil && resp.Offset+n > resp.Size {
+		err = ErrorUploadTooLarge
+	}
+	if err != nil {
+		// Discard the partial chunk, the client resumes at the last offset
+		os.Truncate(s.chunkPath(req.Token), resp.Offset)
+		resp.Err = err.Error()
+		return resp, nil
+	}
+	resp.Offset += n
+
+	if resp.Offset == resp.Size {
+		file, err := s.finishUpload(ctx, resp)
+		if err != nil {
+			resp.Err = err.Error()
+			return resp, nil
+		}
+		resp.File = file
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if resp.File != nil {
+			return tx.Bucket([]byte(UploadBucket)).Delete([]byte(resp.Token))
+		}
+		return putSession(tx, resp)
+	})
+	if err != nil {
+		resp.Err = err.Error()
+	}
+	return resp, nil
+}
+
+// finishUpload moves a completed resumable upload into the storage
+func (s *Service) finishUpload(ctx context.Context, session *UploadSession) (*File, error) {
+	dir, err := s.uploadDir(session.Type, session.Language, session.Slug)
+	if err != nil {
+		return nil, err
+	}
+	src, err := os.Open(s.chunkPath(session.Token))
+	if err != nil {
+		return nil, err
+	}
+	file, err := s.writeFile(ctx, dir, session.Name, src, session.Size, s.fileRule(session.Type, session.Field))
+	src.Close()
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(s.chunkPath(session.Token))
+	return file, nil
+}
+
+// inspectFile reads a stored file to determine its metadata
+func (s *Service) inspectFile(ctx context.Context, key string) (*File, error) {
+	r, err := s.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	contentType, br, err := sniff(r)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, br)
+	if err != nil {
+		return nil, err
+	}
+	return &File{
+		Name:        path.Base(key),
+		Size:        size,
+		URI:         fileURI(key),
+		ContentType: contentType,
+		Checksum:    hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// setFile stores the metadata of file in a file field
+func setFile(filemap map[string]interface{}, file *File) {
+	filemap["name"] = file.Name
+	filemap["size"] = file.Size
+	filemap["uri"] = file.URI
+	filemap["content_type"] = file.ContentType
+	filemap["sha256"] = file.Checksum
+	delete(filemap, "bytes")
+}
+
+// adoptUpload checks a stored file referenced by a file field, staged
+// uploads are moved into the directory of the item. Only staged uploads and
+// files of the item directory can be referenced, the files of an item are
+// deleted with it. The type and size of the file are checked against rule
+// and the metadata of the field is replaced by the metadata read from the
+// storage.
+func (s *Service) adoptUpload(ctx context.Context, filemap map[string]interface{}, contentType, language string, id int64, rule *Field, changes *fileChanges) error {
+	uri, _ := filemap["uri"].(string)
+	src, ok := fileKey(uri)
+	staged := ok && strings.HasPrefix(src, stagingDir(contentType, language)+"/")
+	if !staged && (!ok || !strings.HasPrefix(src, itemDir(contentType, language, id)+"/")) {
+		return ErrorInvalidFileURI
+	}
+
+	file, err := s.inspectFile(ctx, src)
+	if err == ErrorFileNotFound && staged {
+		return ErrorUploadNotFound
+	}
+	if err == ErrorFileNotFound || err == ErrorInvalidFileKey {
+		return ErrorInvalidFileURI
+	}
+	if err != nil {
+		return err
+	}
+	if rule != nil && !rule.accepts(file.ContentType) {
+		return ErrorFileType
+	}
+	if rule != nil && rule.MaxSize > 0 && file.Size > rule.MaxSize {
+		return ErrorFileTooLarge
+	}
+	if !staged {
+		setFile(filemap, file)
+		return nil
+	}
+
+	dst, err := s.freeKey(ctx, itemDir(contentType, language, id), path.Base(src))
+	if err != nil {
+		return err
+	}
+	if err := moveFile(ctx, s.Storage, src, dst); err != nil {
+		return err
+	}
+	changes.moved = append(changes.moved, [2]string{src, dst})
+	file.URI = fileURI(dst)
+	setFile(filemap, file)
+	return nil
+}
+
+// UploadEndpoint - creates endpoint for Upload service
+func UploadEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UploadRequest)
+		return svc.Upload(ctx, &req)
+	}
+}
+
+// StartUploadEndpoint - creates endpoint for StartUpload service
+func StartUploadEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UploadSession)
+		return svc.StartUpload(ctx, &req)
+	}
+}
+
+// UploadStatusEndpoint - creates endpoint for UploadStatus service
+func UploadStatusEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ChunkRequest)
+		return svc.UploadStatus(ctx, &req)
+	}
+}
+
+// UploadChunkEndpoint - creates endpoint for UploadChunk service
+func UploadChunkEndpoint(svc *Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ChunkRequest)
+		return svc.UploadChunk(ctx, &req)
+	}
+}
+
+// UploadSessionType - creates middleware setting the Type of chunk requests
+// from their upload session, it must wrap Authorize so that the policy of
+// the content type is applied
+func UploadSessionType(svc *Service) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			req, ok := request.(ChunkRequest)
+			if !ok {
+				return next(ctx, request)
+			}
+			// Unknown tokens are reported by the endpoint
+			svc.db.View(func(tx *bolt.Tx) error {
+				if session, err := getSession(tx, req.Token); err == nil {
+					req.Type = session.Type
+				}
+				return nil
+			})
+			return next(ctx, req)
+		}
+	}
+}
+
+// DecodeUploadReq - decodes POST /upload?type=&language=&slug=
+func DecodeUploadReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	query := r.URL.Query()
+	request := UploadRequest{
+		Type:     query.Get("type"),
+		Language: query.Get("language"),
+		Slug:     query.Get("slug"),
+		reader:   reader,
+	}
+	return request, nil
+}
+
+// DecodeStartUploadReq - decodes the incoming request
+func DecodeStartUploadReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	var request UploadSession
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// DecodeUploadStatusReq - decodes GET /upload/{token}
+func DecodeUploadStatusReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	return ChunkRequest{Token: mux.Vars(r)["token"]}, nil
+}
+
+// DecodeUploadChunkReq - decodes PATCH /upload/{token}, the Upload-Offset
+// header is the position of the chunk in the file
+func DecodeUploadChunkReq(ctx context.Context, r *http.Request) (interface{}, error) {
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return nil, ErrorUploadOffset
+	}
+	request := ChunkRequest{
+		Token:  mux.Vars(r)["token"],
+		Offset: offset,
+		body:   r.Body,
+	}
+	return request, nil
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+)
+
+func TestAdoptUpload(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	// Item 1 stores article/en/1/a.txt, item 2 is translated to Hindi
+	for _, req := range []*api.CreateRequest{
+		{Type: "article", Language: "en", Slug: "a", Content: fileContent("a.txt", "hi")},
+		{Type: "article", Language: "en", Slug: "b", Content: map[string]interface{}{"title": "b"}},
+		{Type: "article", Language: "hi", Slug: "a", Content: fileContent("a.txt", "hi")},
+	} {
+		if resp, _ := s.Create(ctx, req, false); resp.Err != "" {
+			t.Fatal(resp.Err)
+		}
+	}
+	staged, err := s.writeFile(ctx, stagingDir("article", "en")+"/token", "c.txt", strings.NewReader("ho"), 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		slug string
+		uri  string
+		want string
+		err  error
+	}{
+		{"file of other item", "b", "/drive/article/en/1/a.txt", "", ErrorInvalidFileURI},
+		{"file of other language", "b", "/drive/article/hi/1/a.txt", "", ErrorInvalidFileURI},
+		{"file of other type", "b", "/drive/page/en/2/a.txt", "", ErrorInvalidFileURI},
+		{"outside the storage", "b", "https://example.com/a.txt", "", ErrorInvalidFileURI},
+		{"parent directory", "b", "/drive/article/en/2/../1/a.txt", "", ErrorInvalidFileURI},
+		{"missing staged upload", "b", "/drive/article/en/uploads/token/x.txt", "", ErrorUploadNotFound},
+		{"missing file of item", "a", "/drive/article/en/1/x.txt", "", ErrorInvalidFileURI},
+		{"file of item", "a", "/drive/article/en/1/a.txt", "/drive/article/en/1/a.txt", nil},
+		{"staged upload", "b", staged.URI, "/drive/article/en/2/c.txt", nil},
+	}
+	for _, tt := range tests {
+		content := map[string]interface{}{"file:cover": map[string]interface{}{"uri": tt.uri, "sha256": "claimed"}}
+		resp, _ := s.Update(ctx, &api.UpdateRequest{Type: "article", Language: "en", Slug: tt.slug, Content: content}, false)
+		if tt.err != nil {
+			if resp.Err != tt.err.Error() {
+				t.Errorf("%s: error %q, want %q", tt.name, resp.Err, tt.err)
+			}
+			continue
+		}
+		if resp.Err != "" {
+			t.Errorf("%s: error %q", tt.name, resp.Err)
+			continue
+		}
+		item, _ := resp.Content.(map[string]interface{})
+		file, _ := item["file:cover"].(map[string]interface{})
+		if file["uri"] != tt.want || file["sha256"] == "claimed" {
+			t.Errorf("%s: file %v, want uri %s with stored checksum", tt.name, file, tt.want)
+		}
+	}
+
+	// Other items never lose the files they reference
+	if _, err := s.Storage.Stat(ctx, "article/en/1/a.txt"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUploadSessionType(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	if resp, _ := s.CreateType(ctx, &TypeDefinition{Name: "secret"}); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	policy := DefaultPolicy()
+	policy.Types = map[string]map[Operation]Role{"secret": {OpCreate: RoleAdmin}}
+
+	tokens := map[string]string{}
+	for _, contentType := range []string{"article", "secret"} {
+		session, _ := s.StartUpload(ctx, &UploadSession{Type: contentType, Language: "en", Name: "a.txt", Size: 2})
+		if session.Err != "" {
+			t.Fatal(session.Err)
+		}
+		tokens[contentType] = session.Token
+	}
+
+	// Chunk requests carry only the token, the session names the type
+	status := UploadSessionType(s)(Authorize(policy, OpCreate)(UploadStatusEndpoint(s)))
+	editor := WithUser(ctx, &User{Name: "e", Role: RoleEditor})
+	admin := WithUser(ctx, &User{Name: "a", Role: RoleAdmin})
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		token string
+		err   error
+	}{
+		{"editor uploading article", editor, tokens["article"], nil},
+		{"editor uploading secret", editor, tokens["secret"], ErrorForbidden},
+		{"admin uploading secret", admin, tokens["secret"], nil},
+		{"anonymous uploading article", ctx, tokens["article"], ErrorUnauthorized},
+	}
+	for _, tt := range tests {
+		resp, err := status(tt.ctx, ChunkRequest{Token: tt.token})
+		if err != tt.err {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+		}
+		if session, ok := resp.(*UploadSession); err == nil && (!ok || session.Err != "") {
+			t.Errorf("%s: response %+v", tt.name, resp)
+		}
+	}
+}
@@ -1,27 +1,54 @@
 package service
 
 import (
-	"strings"
+	"reflect"
+	"time"
 )
 
-func stringToSlug(title string) string {
-	// Filter and conver to lowercase
-	slug := func(r rune) rune {
-		switch {
-		case r >= 'A' && r <= 'Z':
-			return r + 'a' - 'A'
-		case r >= 'a' && r <= 'z':
-			return r
-		case r >= '0' && r <= '9':
-			return r
-		}
-		return ' '
+// stringField returns the string field name of a struct or struct pointer
+func stringField(v interface{}, name string) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
 	}
+	if rv.Kind() != reflect.Struct {
+		return ""
+	}
+	if f := rv.FieldByName(name); f.IsValid() && f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
 
-	// Convert whitespace to hyphen '-'
-	str := strings.Map(slug, title)
-	strarray := strings.Fields(str)
-	str = strings.Join(strarray, "-")
+// notDeleted is the deleted_at value of items which are not in trash
+var notDeleted = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+
+// isTrashed reports whether the item was deleted
+func isTrashed(item map[string]interface{}) bool {
+	deletedAt, ok := toInt64(item["deleted_at"])
+	return ok && deletedAt != notDeleted
+}
 
-	return str
+// toFloat64 converts a number of any type
+func toFloat64(v interface{}) (float64, bool) {
+	if f, ok := v.(float64); ok {
+		return f, true
+	}
+	n, ok := toInt64(v)
+	return float64(n), ok
+}
+
+// toInt64 converts a number, which might be decoded from JSON as float64
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
 }
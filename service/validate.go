@@ -0,0 +1,285 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"git.urantiatech.com/cloudcms/cloudcms/item"
+)
+
+// ErrorValidation is returned when content doesn't match its field definitions
+var ErrorValidation = errors.New("Validation failed")
+
+// Field describes a field of a content type and its validation rules
+type Field struct {
+	Name      string        `json:"name"`
+	Type      string        `json:"type"`
+	Widget    string        `json:"widget,omitempty"`
+	Required  bool          `json:"required,omitempty"`
+	Enum      []interface{} `json:"enum,omitempty"`
+	Min       *float64      `json:"min,omitempty"`
+	Max       *float64      `json:"max,omitempty"`
+	Pattern   string        `json:"pattern,omitempty"`
+	MaxLength int           `json:"max_length,omitempty"`
+
+	// Accept lists the MIME types allowed in a file field such as
+	// "image/png" or "image/*", MaxSize limits its size in bytes
+	Accept  []string `json:"accept,omitempty"`
+	MaxSize int64    `json:"max_size,omitempty"`
+
+	// Analyzer overrides the language analyzer of a text field in the
+	// search indexes, e.g. "keyword" to match whole values
+	Analyzer string `json:"analyzer,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// FieldError is the validation error of a single field
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// ValidationError lists the errors of all invalid fields
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	var list []string
+	for _, f := range e {
+		list = append(list, f.Field+": "+f.Error)
+	}
+	return ErrorValidation.Error() + ": " + strings.Join(list, "; ")
+}
+
+// systemFields are maintained by the service and never validated
+var systemFields = map[string]bool{
+	"id":           true,
+	"slug":         true,
+	"language":     true,
+	"status":       true,
+	"created_at":   true,
+	"updated_at":   true,
+	"deleted_at":   true,
+	"publish_at":   true,
+	"unpublish_at": true,
+	"version":      true,
+
+	"translation_group": true,
+}
+
+// readOnlyFields are the system fields an update can't change, the slug is
+// changed by Rename and deleted_at by Delete and Restore
+var readOnlyFields = map[string]bool{
+	"id":         true,
+	"slug":       true,
+	"language":   true,
+	"created_at": true,
+	"updated_at": true,
+	"deleted_at": true,
+	"version":    true,
+
+	"translation_group": true,
+}
+
+// loadFields decodes the field definitions registered in item.Fields
+func loadFields() map[string][]Field {
+	fields := make(map[string][]Field)
+	for t, v := range item.Fields {
+		var list []Field
+		b, err := json.Marshal(v)
+		if err == nil {
+			err = json.Unmarshal(b, &list)
+		}
+		if err != nil {
+			log.Printf("Field definitions of %s are not validated: %s", t, err.Error())
+			continue
+		}
+		fields[t] = compileFields(list)
+	}
+	return fields
+}
+
+// compileFields compiles the patterns of field definitions
+func compileFields(list []Field) []Field {
+	for i := range list {
+		if list[i].Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(list[i].Pattern)
+		if err != nil {
+			log.Printf("Invalid pattern of field %s: %s", list[i].Name, err.Error())
+			continue
+		}
+		list[i].re = re
+	}
+	return list
+}
+
+// validate checks content against the field definitions of content type,
+// unknown fields are rejected if StrictFields is set
+func (s *Service) validate(contentType string, content map[string]interface{}) error {
+	fields := s.fieldsOf(contentType)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	var errs ValidationError
+	known := make(map[string]bool)
+	for _, f := range fields {
+		key := f.Name
+		if _, ok := content[key]; !ok && f.Type == "file" {
+			key = "file:" + f.Name
+		}
+		known[key] = true
+
+		v, ok := content[key]
+		if !ok || v == nil || v == "" {
+			if f.Required {
+				errs = append(errs, FieldError{Field: key, Error: "required"})
+			}
+			continue
+		}
+		if msg := f.check(v); msg != "" {
+			errs = append(errs, FieldError{Field: key, Error: msg})
+		}
+	}
+
+	if s.StrictFields {
+		for k := range content {
+			if !known[k] && !systemFields[k] {
+				errs = append(errs, FieldError{Field: k, Error: "unknown field"})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// check validates a single value, it returns the error message
+func (f *Field) check(v interface{}) string {
+	switch f.Type {
+	case "string", "text", "textarea", "html", "markdown", "email", "url":
+		str, ok := v.(string)
+		if !ok {
+			return "must be a string"
+		}
+		if f.MaxLength > 0 && utf8.RuneCountInString(str) > f.MaxLength {
+			return fmt.Sprintf("must be at most %d characters", f.MaxLength)
+		}
+		if f.re != nil && !f.re.MatchString(str) {
+			return "must match " + f.Pattern
+		}
+	case "int", "integer":
+		n, ok := toFloat64(v)
+		if !ok || n != float64(int64(n)) {
+			return "must be an integer"
+		}
+		if msg := f.checkRange(n); msg != "" {
+			return msg
+		}
+	case "number", "float":
+		n, ok := toFloat64(v)
+		if !ok {
+			return "must be a number"
+		}
+		if msg := f.checkRange(n); msg != "" {
+			return msg
+		}
+	case "bool", "boolean":
+		if _, ok := v.(bool); !ok {
+			return "must be a boolean"
+		}
+	case "date", "datetime", "time":
+		switch t := v.(type) {
+		case float64, int, int64:
+		case string:
+			if _, err := time.Parse(time.RFC3339, t); err != nil {
+				if _, err := time.Parse("2006-01-02", t); err != nil {
+					return "must be a RFC 3339 date"
+				}
+			}
+		default:
+			return "must be a date"
+		}
+	case "file":
+		if _, ok := v.(map[string]interface{}); !ok {
+			return "must be a file"
+		}
+	case "list", "array", "tags":
+		list, ok := v.([]interface{})
+		if !ok {
+			return "must be a list"
+		}
+		if f.MaxLength > 0 && len(list) > f.MaxLength {
+			return fmt.Sprintf("must have at most %d entries", f.MaxLength)
+		}
+	}
+
+	if len(f.Enum) > 0 {
+		for _, e := range f.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				return ""
+			}
+		}
+		return "must be one of the allowed values"
+	}
+	return ""
+}
+
+// accepts reports whether a file of contentType may be stored in the field
+func (f *Field) accepts(contentType string) bool {
+	if len(f.Accept) == 0 {
+		return true
+	}
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mt
+	}
+	for _, a := range f.Accept {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == "*/*" || a == contentType ||
+			(strings.HasSuffix(a, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(a, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileRule returns the definition of the file field key of contentType,
+// key may have the "file:" prefix
+func (s *Service) fileRule(contentType, key string) *Field {
+	name := strings.TrimPrefix(key, "file:")
+	for _, f := range s.fieldsOf(contentType) {
+		if f.Name == name && f.Type == "file" {
+			return &f
+		}
+	}
+	return nil
+}
+
+func (f *Field) checkRange(n float64) string {
+	if f.Min != nil && n < *f.Min {
+		return fmt.Sprintf("must be at least %v", *f.Min)
+	}
+	if f.Max != nil && n > *f.Max {
+		return fmt.Sprintf("must be at most %v", *f.Max)
+	}
+	return ""
+}
+
+// validationResponse reports field errors in the response content
+func validationResponse(err error) (string, interface{}) {
+	if errs, ok := err.(ValidationError); ok {
+		return ErrorValidation.Error(), map[string]interface{}{"errors": []FieldError(errs)}
+	}
+	return err.Error(), nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+)
+
+func TestFieldCheck(t *testing.T) {
+	min, max := 1.0, 5.0
+	tests := []struct {
+		field Field
+		value interface{}
+		want  string
+	}{
+		{Field{Type: "string"}, "a", ""},
+		{Field{Type: "string"}, 1.0, "must be a string"},
+		{Field{Type: "string", MaxLength: 3}, "नमस्ते", "must be at most 3 characters"},
+		{Field{Type: "string", MaxLength: 6}, "नमस्ते", ""},
+		{Field{Type: "string", Pattern: "^[a-z]+$"}, "abc", ""},
+		{Field{Type: "string", Pattern: "^[a-z]+$"}, "ab1", "must match ^[a-z]+$"},
+		{Field{Type: "int"}, 2.0, ""},
+		{Field{Type: "int"}, 2.5, "must be an integer"},
+		{Field{Type: "int", Min: &min, Max: &max}, 0.0, "must be at least 1"},
+		{Field{Type: "number", Min: &min, Max: &max}, 5.5, "must be at most 5"},
+		{Field{Type: "number"}, "5", "must be a number"},
+		{Field{Type: "bool"}, "true", "must be a boolean"},
+		{Field{Type: "date"}, "2024-02-30", "must be a RFC 3339 date"},
+		{Field{Type: "date"}, "2024-02-03T10:00:00Z", ""},
+		{Field{Type: "file"}, "a.png", "must be a file"},
+		{Field{Type: "list", MaxLength: 1}, []interface{}{"a", "b"}, "must have at most 1 entries"},
+		{Field{Type: "string", Enum: []interface{}{"a", "b"}}, "b", ""},
+		{Field{Type: "string", Enum: []interface{}{"a", "b"}}, "c", "must be one of the allowed values"},
+	}
+	for _, tt := range tests {
+		f := compileFields([]Field{tt.field})[0]
+		if got := f.check(tt.value); got != tt.want {
+			t.Errorf("%s field, value %v: check() = %q, want %q", tt.field.Type, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateValidation(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	s.StrictFields = true
+
+	def := &TypeDefinition{Name: "article", Fields: []Field{
+		{Name: "title", Type: "string", Required: true, MaxLength: 10},
+		{Name: "rating", Type: "int"},
+	}}
+	if resp, _ := s.UpdateType(ctx, def); resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	resp, _ := s.Create(ctx, &api.CreateRequest{Type: "article", Language: "en", Slug: "a", Content: map[string]interface{}{"title": "a"}}, false)
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	item := resp.Content.(map[string]interface{})
+
+	tests := []struct {
+		name    string
+		content map[string]interface{}
+		err     error
+		fields  []string
+	}{
+		{"unknown field", map[string]interface{}{"titel": "b"}, ErrorValidation, []string{"titel"}},
+		{"wrong types", map[string]interface{}{"title": 1.0, "rating": "5"}, ErrorValidation, []string{"title", "rating"}},
+		{"required field", map[string]interface{}{"title": ""}, ErrorValidation, []string{"title"}},
+
+		// Invalid content is reported before a stale version
+		{"invalid and stale", map[string]interface{}{"title": "much too long", "version": 0.0}, ErrorValidation, []string{"title"}},
+		{"stale version", map[string]interface{}{"title": "b", "version": 0.0}, ErrorConflict, nil},
+
+		// The item read by the client can be sent back
+		{"read item", map[string]interface{}{"id": item["id"], "slug": "b", "version": item["version"], "title": "b"}, nil, nil},
+	}
+	for _, tt := range tests {
+		resp, _ := s.Update(ctx, &api.UpdateRequest{Type: "article", Language: "en", Slug: "a", Content: tt.content}, false)
+		if tt.err == nil && resp.Err != "" || tt.err != nil && resp.Err != tt.err.Error() {
+			t.Errorf("%s: error %q, want %v", tt.name, resp.Err, tt.err)
+			continue
+		}
+		if tt.err != ErrorValidation {
+			continue
+		}
+		content, _ := resp.Content.(map[string]interface{})
+		errs, _ := content["errors"].([]FieldError)
+		if len(errs) != len(tt.fields) {
+			t.Errorf("%s: field errors %v, want %v", tt.name, errs, tt.fields)
+			continue
+		}
+		for _, f := range tt.fields {
+			var found bool
+			for _, e := range errs {
+				found = found || e.Field == f
+			}
+			if !found {
+				t.Errorf("%s: no error of field %s in %v", tt.name, f, errs)
+			}
+		}
+	}
+
+	// Drafts are read by editors
+	editor := WithUser(ctx, &User{Name: "e", Role: RoleEditor})
+	resp, _ = s.Read(editor, &api.ReadRequest{Type: "article", Language: "en", Slug: "a"})
+	content, _ := resp.Content.(map[string]interface{})
+	if content["title"] != "b" || content["slug"] != "a" || itemVersion(content) != 2 {
+		t.Errorf("updated item %v", content)
+	}
+}
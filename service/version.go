@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrorConflict is returned when an item was changed since the version
+// expected by the request
+var ErrorConflict = errors.New("Version conflict")
+
+// WithVersion returns a context expecting items to be at version, it is
+// used by requests without a content such as Delete
+func WithVersion(ctx context.Context, version int64) context.Context {
+	return context.WithValue(ctx, versionKey, version)
+}
+
+// VersionFromContext returns the version expected by the request
+func VersionFromContext(ctx context.Context) (int64, bool) {
+	version, ok := ctx.Value(versionKey).(int64)
+	return version, ok
+}
+
+// itemVersion returns the version of an item, items created before
+// versioning are at version 0
+func itemVersion(content map[string]interface{}) int64 {
+	version, _ := toInt64(content["version"])
+	return version
+}
+
+// expectedVersion removes the version from the fields of a write request,
+// the version in context is used if fields has none
+func expectedVersion(ctx context.Context, fields map[string]interface{}) (int64, bool) {
+	if v, ok := fields["version"]; ok {
+		delete(fields, "version")
+		if version, ok := toInt64(v); ok {
+			return version, true
+		}
+	}
+	return VersionFromContext(ctx)
+}
+
+// checkVersion rejects stale writes of the current item
+func checkVersion(ctx context.Context, current, fields map[string]interface{}) error {
+	if version, ok := expectedVersion(ctx, fields); ok && version != itemVersion(current) {
+		return ErrorConflict
+	}
+	return nil
+}
+
+// nextVersion increments the version of an item being written
+func nextVersion(content map[string]interface{}, current int64) {
+	content["version"] = current + 1
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		current map[string]interface{}
+		fields  map[string]interface{}
+		err     error
+	}{
+		{"no version expected", context.Background(),
+			map[string]interface{}{"version": 3.0}, map[string]interface{}{"title": "x"}, nil},
+		{"current version", context.Background(),
+			map[string]interface{}{"version": 3.0}, map[string]interface{}{"version": 3.0}, nil},
+		{"stale version", context.Background(),
+			map[string]interface{}{"version": 3.0}, map[string]interface{}{"version": 2.0}, ErrorConflict},
+		{"future version", context.Background(),
+			map[string]interface{}{"version": 3.0}, map[string]interface{}{"version": 4.0}, ErrorConflict},
+		{"unversioned item", context.Background(),
+			map[string]interface{}{}, map[string]interface{}{"version": 0.0}, nil},
+		{"unversioned item is stale", context.Background(),
+			map[string]interface{}{}, map[string]interface{}{"version": 1.0}, ErrorConflict},
+		{"version in context", WithVersion(context.Background(), 3),
+			map[string]interface{}{"version": 3.0}, map[string]interface{}{}, nil},
+		{"stale version in context", WithVersion(context.Background(), 2),
+			map[string]interface{}{"version": 3.0}, map[string]interface{}{}, ErrorConflict},
+		{"fields override context", WithVersion(context.Background(), 2),
+			map[string]interface{}{"version": 3.0}, map[string]interface{}{"version": 3.0}, nil},
+		{"invalid version uses context", WithVersion(context.Background(), 2),
+			map[string]interface{}{"version": 3.0}, map[string]interface{}{"version": "3"}, ErrorConflict},
+	}
+	for _, tt := range tests {
+		if err := checkVersion(tt.ctx, tt.current, tt.fields); err != tt.err {
+			t.Errorf("%s: checkVersion() = %v, want %v", tt.name, err, tt.err)
+		}
+		if _, ok := tt.fields["version"]; ok {
+			t.Errorf("%s: version left in fields", tt.name)
+		}
+	}
+}
+
+func TestNextVersion(t *testing.T) {
+	content := map[string]interface{}{"version": 3.0}
+	nextVersion(content, itemVersion(content))
+	if v := itemVersion(content); v != 4 {
+		t.Errorf("nextVersion() = %d, want 4", v)
+	}
+}
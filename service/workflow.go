@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	q "github.com/blevesearch/bleve/search/query"
+	"github.com/boltdb/bolt"
+)
+
+// Workflow states of an item
+const (
+	StatusDraft     = "draft"
+	StatusReview    = "in-review"
+	StatusPublished = "published"
+	StatusArchived  = "archived"
+)
+
+// Workflow errors
+var (
+	ErrorInvalidStatus     = errors.New("Invalid status")
+	ErrorInvalidTransition = errors.New("Status transition not allowed")
+	ErrorInvalidSchedule   = errors.New("Invalid publish_at or unpublish_at")
+)
+
+// workflowKey is set in MetaBucket once existing content was migrated
+var workflowKey = []byte("workflow")
+
+// transitions lists the states reachable from each state
+var transitions = map[string][]string{
+	StatusDraft:     {StatusReview, StatusPublished, StatusArchived},
+	StatusReview:    {StatusDraft, StatusPublished},
+	StatusPublished: {StatusDraft, StatusArchived},
+	StatusArchived:  {StatusDraft},
+}
+
+// checkTransition validates a status change of an item
+func checkTransition(from, to string) error {
+	if _, ok := transitions[to]; !ok {
+		return ErrorInvalidStatus
+	}
+	if from == to {
+		return nil
+	}
+	for _, s := range transitions[from] {
+		if s == to {
+			return nil
+		}
+	}
+	return ErrorInvalidTransition
+}
+
+// checkWorkflow validates the status and schedule fields of content,
+// current is nil for new items
+func checkWorkflow(current, content map[string]interface{}) error {
+	status, ok := content["status"].(string)
+	if !ok {
+		return ErrorInvalidStatus
+	}
+	if current == nil {
+		if _, ok := transitions[status]; !ok {
+			return ErrorInvalidStatus
+		}
+	} else {
+		from, _ := current["status"].(string)
+		if err := checkTransition(from, status); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range []string{"publish_at", "unpublish_at"} {
+		if v, ok := content[k]; ok && v != nil {
+			if _, ok := toInt64(v); !ok {
+				return ErrorInvalidSchedule
+			}
+		}
+	}
+	return nil
+}
+
+// isPublic reports whether the request may only see published content
+func isPublic(ctx context.Context) bool {
+	user := UserFromContext(ctx)
+	return user == nil || user.Role < RoleEditor
+}
+
+// statusQuery matches items in status
+func statusQuery(status string) q.Query {
+	query := bleve.NewMatchPhraseQuery(status)
+	query.SetField("status")
+	return query
+}
+
+// publicQuery restricts query to published items for public requests
+func publicQuery(ctx context.Context, query q.Query) q.Query {
+	if !isPublic(ctx) {
+		return query
+	}
+	return bleve.NewConjunctionQuery(query, statusQuery(StatusPublished))
+}
+
+// migrateStatus publishes items created before the workflow existed, they
+// were stored with an empty status
+func (s *Service) migrateStatus(tx *bolt.Tx, contentType, language string) error {
+	bb, err := getBucket(tx, contentType, language)
+	if err != nil {
+		return err
+	}
+
+	updates := make(map[string][]byte)
+	err = bb.ForEach(func(k, v []byte) error {
+		var content map[string]interface{}
+		if err := json.Unmarshal(v, &content); err != nil {
+			return err
+		}
+		if status, _ := content["status"].(string); status != "" {
+			return nil
+		}
+		content["status"] = StatusPublished
+		j, err := json.Marshal(content)
+		if err != nil {
+			return err
+		}
+		updates[string(k)] = j
+		return nil
+	})
+	if err != nil || len(updates) == 0 {
+		return err
+	}
+
+	for k, v := range updates {
+		if err := bb.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	// Indexes built before the migration are stale now
+	_, err = bumpRevision(tx, contentType, language)
+	return err
+}
+
+// StartScheduler publishes and unpublishes items at their publish_at and
+// unpublish_at times, checking every interval until Close is called
+func (s *Service) StartScheduler(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.schedule(time.Now())
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopScheduler waits for the running schedule to complete
+func (s *Service) stopScheduler() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+// scheduled transitions, applied when the time in field is reached
+var scheduled = []struct {
+	field string
+	from  []string
+	to    string
+}{
+	{"publish_at", []string{StatusDraft, StatusReview}, StatusPublished},
+	{"unpublish_at", []string{StatusPublished}, StatusArchived},
+}
+
+// schedule applies all publish_at and unpublish_at times reached at now
+func (s *Service) schedule(now time.Time) {
+	s.langMu.RLock()
+	defer s.langMu.RUnlock()
+
+	for t, languages := range s.indexes() {
+		for l, index := range languages {
+			// Archived languages are read-only
+			if s.isArchived(l) {
+				continue
+			}
+			for _, st := range scheduled {
+				slugs, err := dueItems(index, st.field, now)
+				if err != nil {
+					log.Printf("Scheduler %s/%s: %s", t, l, err.Error())
+					continue
+				}
+				for _, slug := range slugs {
+					if err := s.transition(t, l, slug, st.field, st.from, st.to, now); err != nil {
+						log.Printf("Scheduler %s/%s/%s: %s", t, l, slug, err.Error())
+					}
+				}
+			}
+		}
+	}
+}
+
+// dueItems returns the slugs of items whose field is set and not after now
+func dueItems(index bleve.Index, field string, now time.Time) ([]string, error) {
+	min, max := 1.0, float64(now.Unix())
+	inclusive := true
+	query := bleve.NewNumericRangeInclusiveQuery(&min, &max, &inclusive, &inclusive)
+	query.SetField(field)
+
+	searchRequest := bleve.NewSearchRequest(query)
+	searchRequest.Size = math.MaxInt32
+	searchResult, err := index.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	var slugs []string
+	for _, hit := range searchResult.Hits {
+		slugs = append(slugs, hit.ID)
+	}
+	return slugs, nil
+}
+
+// transition moves a scheduled item from one of the from states into status
+// and clears field
+func (s *Service) transition(contentType, language, slug, field string, from []string, status string, now time.Time) error {
+	ctx := WithUser(context.Background(), &User{Name: "scheduler", Role: RoleAdmin})
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bb, err := s.bucket(tx, contentType, language)
+		if err != nil {
+			return err
+		}
+		var content map[string]interface{}
+		val := bb.Get([]byte(slug))
+		if val == nil {
+			return nil
+		}
+		if err := json.Unmarshal(val, &content); err != nil {
+			return err
+		}
+		if at, ok := toInt64(content[field]); !ok || at <= 0 || at > now.Unix() || isTrashed(content) {
+			return nil
+		}
+
+		// Items moved out of the from states by hand keep their schedule
+		current, _ := content["status"].(string)
+		allowed := false
+		for _, f := range from {
+			allowed = allowed || current == f
+		}
+		if !allowed {
+			return nil
+		}
+
+		delete(content, field)
+		content["status"] = status
+		content["updated_at"] = now.Unix()
+		nextVersion(content, itemVersion(content))
+
+		j, err := json.Marshal(content)
+		if err != nil {
+			return err
+		}
+		if err := bb.Put([]byte(slug), j); err != nil {
+			return err
+		}
+		if _, err := s.addRevision(ctx, tx, contentType, language, "schedule", content); err != nil {
+			return err
+		}
+		if err := putGroupMember(tx, contentType, language, content); err != nil {
+			return err
+		}
+
+		index, err := s.getIndex(contentType, language)
+		if err != nil {
+			return err
+		}
+		if err := index.Index(slug, content); err != nil {
+			return err
+		}
+		return syncRevision(tx, index, contentType, language)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Drop the cached responses
+	s.cache.invalidate(contentType, language)
+	return nil
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"git.urantiatech.com/cloudcms/cloudcms/api"
+	"github.com/boltdb/bolt"
+)
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		err      error
+	}{
+		{StatusDraft, StatusDraft, nil},
+		{StatusDraft, StatusReview, nil},
+		{StatusDraft, StatusPublished, nil},
+		{StatusDraft, StatusArchived, nil},
+		{StatusReview, StatusDraft, nil},
+		{StatusReview, StatusPublished, nil},
+		{StatusReview, StatusArchived, ErrorInvalidTransition},
+		{StatusPublished, StatusDraft, nil},
+		{StatusPublished, StatusArchived, nil},
+		{StatusPublished, StatusReview, ErrorInvalidTransition},
+		{StatusArchived, StatusDraft, nil},
+		{StatusArchived, StatusPublished, ErrorInvalidTransition},
+		{StatusArchived, StatusReview, ErrorInvalidTransition},
+		{StatusDraft, "bogus", ErrorInvalidStatus},
+		{StatusDraft, "", ErrorInvalidStatus},
+		{"", StatusDraft, ErrorInvalidTransition},
+	}
+	for _, tt := range tests {
+		if err := checkTransition(tt.from, tt.to); err != tt.err {
+			t.Errorf("checkTransition(%q, %q) = %v, want %v", tt.from, tt.to, err, tt.err)
+		}
+	}
+}
+
+func TestCheckWorkflow(t *testing.T) {
+	tests := []struct {
+		name    string
+		current map[string]interface{}
+		content map[string]interface{}
+		err     error
+	}{
+		{"new draft", nil, map[string]interface{}{"status": StatusDraft}, nil},
+		{"new published", nil, map[string]interface{}{"status": StatusPublished}, nil},
+		{"new without status", nil, map[string]interface{}{}, ErrorInvalidStatus},
+		{"new invalid status", nil, map[string]interface{}{"status": "bogus"}, ErrorInvalidStatus},
+		{"status not a string", nil, map[string]interface{}{"status": 1.0}, ErrorInvalidStatus},
+		{"allowed transition",
+			map[string]interface{}{"status": StatusReview},
+			map[string]interface{}{"status": StatusPublished}, nil},
+		{"forbidden transition",
+			map[string]interface{}{"status": StatusArchived},
+			map[string]interface{}{"status": StatusPublished}, ErrorInvalidTransition},
+		{"schedule",
+			nil,
+			map[string]interface{}{"status": StatusDraft, "publish_at": 1700000000.0, "unpublish_at": nil}, nil},
+		{"invalid publish_at",
+			nil,
+			map[string]interface{}{"status": StatusDraft, "publish_at": "tomorrow"}, ErrorInvalidSchedule},
+		{"invalid unpublish_at",
+			map[string]interface{}{"status": StatusDraft},
+			map[string]interface{}{"status": StatusDraft, "unpublish_at": true}, ErrorInvalidSchedule},
+	}
+	for _, tt := range tests {
+		if err := checkWorkflow(tt.current, tt.content); err != tt.err {
+			t.Errorf("%s: checkWorkflow() = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	now := time.Now()
+	past, future := now.Add(-time.Minute).Unix(), now.Add(time.Hour).Unix()
+
+	tests := []struct {
+		slug    string
+		content map[string]interface{}
+		status  string
+		field   string
+		version int64
+	}{
+		{"due-draft", map[string]interface{}{"status": StatusDraft, "publish_at": past}, StatusPublished, "", 2},
+		{"due-review", map[string]interface{}{"status": StatusReview, "publish_at": past}, StatusPublished, "", 2},
+		{"future-draft", map[string]interface{}{"status": StatusDraft, "publish_at": future}, StatusDraft, "publish_at", 1},
+		{"due-published", map[string]interface{}{"status": StatusPublished, "unpublish_at": past}, StatusArchived, "", 2},
+		{"archived-by-hand", map[string]interface{}{"status": StatusArchived, "publish_at": past}, StatusArchived, "publish_at", 1},
+		{"draft-unpublished", map[string]interface{}{"status": StatusDraft, "unpublish_at": past}, StatusDraft, "unpublish_at", 1},
+	}
+	for _, tt := range tests {
+		if resp, _ := s.Create(ctx, &api.CreateRequest{Type: "article", Language: "en", Slug: tt.slug, Content: tt.content}, false); resp.Err != "" {
+			t.Fatalf("%s: %s", tt.slug, resp.Err)
+		}
+	}
+	s.schedule(now)
+
+	s.db.View(func(tx *bolt.Tx) error {
+		bb, err := s.bucket(tx, "article", "en")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			var content map[string]interface{}
+			json.Unmarshal(bb.Get([]byte(tt.slug)), &content)
+			if content["status"] != tt.status || itemVersion(content) != tt.version {
+				t.Errorf("%s: status %v at version %d, want %s at version %d", tt.slug, content["status"], itemVersion(content), tt.status, tt.version)
+			}
+			for _, field := range []string{"publish_at", "unpublish_at"} {
+				if _, ok := content[field]; ok != (field == tt.field) {
+					t.Errorf("%s: %s is %v", tt.slug, field, content[field])
+				}
+			}
+		}
+		return nil
+	})
+}